@@ -1,7 +1,13 @@
 package controllers
 
 import (
+	"bytes"
+	"errors"
+	"image"
+	"log"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"clipmini/models"
@@ -10,34 +16,193 @@ import (
 )
 
 type ClipboardController struct {
-	clipboardService *services.ClipboardService
+	backend          services.ClipboardBackend
 	historyService   *services.HistoryService
 	fileService      *services.FileService
+	thumbnails       *services.ThumbnailCache
 	config           *models.AppConfig
+	privacyFilter    *services.PrivacyFilter
+	retention        *services.RetentionPolicy
+	keyInfo          *services.KeyInfo
+	lockInfo         *services.KeyInfo
 	lastText         string
 	lastImgHash      string
+	lastToken        string
+	// poller is the watcher given to Watch when it can change its interval
+	poller           interface{ SetInterval(time.Duration) }
+	// mu serializes capture, the retention janitor and settings changes.
+	// The history service has its own lock, so reads from the UI need none.
+	mu               sync.Mutex
 }
 
-func NewClipboardController(config *models.AppConfig) *ClipboardController {
+func NewClipboardController(config *models.AppConfig, backend services.ClipboardBackend) *ClipboardController {
+	fileService := services.NewFileService(config)
 	return &ClipboardController{
-		clipboardService: services.NewClipboardService(),
-		historyService:   services.NewHistoryService(config),
-		fileService:      services.NewFileService(config),
+		backend:          backend,
+		historyService:   services.NewHistoryService(config, fileService),
+		fileService:      fileService,
+		thumbnails:       services.NewThumbnailCache(fileService, min(runtime.NumCPU(), 4)),
 		config:           config,
 	}
 }
 
+// OpenVault reads the encryption settings and must run before Initialize. It
+// finishes an interrupted change of key, unlocks with the configured key file
+// when there is one and reports whether a passphrase is still needed.
+func (cc *ClipboardController) OpenVault() (bool, error) {
+	if err := cc.fileService.RecoverRekey(); err != nil {
+		return false, err
+	}
+	keyInfo, err := services.LoadKeyInfo(cc.config.KeyInfoPath)
+	if err != nil || keyInfo == nil {
+		return false, err
+	}
+	cc.keyInfo = keyInfo
+
+	if keyInfo.Source != services.KeySourceKeyFile {
+		return true, nil
+	}
+	if cc.config.KeyFilePath == "" {
+		return false, errors.New("history is encrypted with a key file, set CLIPMINI_KEY_FILE")
+	}
+	secret, err := services.ReadKeyFile(cc.config.KeyFilePath)
+	if err != nil {
+		return false, err
+	}
+	return false, cc.fileService.Vault().Unlock(keyInfo, secret)
+}
+
+func (cc *ClipboardController) Unlock(passphrase string) error {
+	if cc.keyInfo == nil {
+		return nil
+	}
+	if err := cc.fileService.Vault().Unlock(cc.keyInfo, []byte(passphrase)); err != nil {
+		return err
+	}
+	// Thumbnails that failed while locked can be built now
+	cc.thumbnails.Reset()
+	return nil
+}
+
+func (cc *ClipboardController) EncryptionEnabled() bool {
+	return cc.keyInfo != nil
+}
+
+// EnableEncryption encrypts the existing history and images with a key
+// derived from passphrase.
+func (cc *ClipboardController) EnableEncryption(passphrase string) error {
+	if cc.keyInfo != nil {
+		return errors.New("encryption is already enabled")
+	}
+	if passphrase == "" {
+		return errors.New("passphrase must not be empty")
+	}
+	return cc.rekey(services.KeySourcePassphrase, []byte(passphrase))
+}
+
+// ChangePassphrase re-encrypts everything with a key derived from next. A
+// history encrypted with a key file switches to the passphrase.
+func (cc *ClipboardController) ChangePassphrase(current, next string) error {
+	if cc.keyInfo == nil {
+		return errors.New("encryption is not enabled")
+	}
+	if next == "" {
+		return errors.New("passphrase must not be empty")
+	}
+	if cc.keyInfo.Source == services.KeySourcePassphrase {
+		if _, err := cc.keyInfo.Verify([]byte(current)); err != nil {
+			return err
+		}
+	}
+	return cc.rekey(services.KeySourcePassphrase, []byte(next))
+}
+
+// rekey re-encrypts everything with a key derived from secret. The new key
+// info only replaces the old one once all data was written with the new key
+// next to the originals; see HistoryService.Rekey.
+func (cc *ClipboardController) rekey(source string, secret []byte) error {
+	keyInfo, key, err := services.CreateKeyInfo(source, secret)
+	if err != nil {
+		return err
+	}
+
+	// Capture must not write an image with the old key halfway through
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if err := cc.fileService.StageKeyInfo(keyInfo); err != nil {
+		return err
+	}
+	committed := false
+	err = cc.historyService.Rekey(key, func() error {
+		if err := cc.fileService.CommitKeyInfo(); err != nil {
+			return err
+		}
+		committed = true
+		cc.keyInfo = keyInfo
+		return nil
+	})
+	if err != nil {
+		// Before the commit the old key still holds, so drop the staged
+		// files; after it, the next start swaps them in.
+		if !committed {
+			if rerr := cc.fileService.RecoverRekey(); rerr != nil {
+				log.Printf("Failed to undo re-encryption: %v", rerr)
+			}
+		}
+		return err
+	}
+	cc.fileService.RemovePlaintextBackups()
+	return nil
+}
+
 func (cc *ClipboardController) Initialize() error {
-	if err := cc.historyService.LoadFromFile(); err != nil {
+	privacyFilter, err := services.NewPrivacyFilter(cc.config.Privacy)
+	if err != nil {
+		return err
+	}
+	cc.privacyFilter = privacyFilter
+
+	retention, err := services.NewRetentionPolicy(cc.config.Retention, cc.config.Location())
+	if err != nil {
+		return err
+	}
+	cc.retention = retention
+
+	if cc.lockInfo, err = services.LoadKeyInfo(cc.config.LockInfoPath); err != nil {
+		return err
+	}
+
+	if err := cc.historyService.Load(); err != nil {
 		return err
 	}
 
-	if cc.clipboardService.HasImageInClipboard() {
-		if b, err := cc.clipboardService.ReadClipboardImage(); err == nil && len(b) > 0 {
-			cc.lastImgHash = cc.clipboardService.GetImageHash(b)
+	// 設定了金鑰檔但尚未加密時，直接加密現有紀錄
+	if cc.keyInfo == nil && cc.config.KeyFilePath != "" {
+		secret, err := services.ReadKeyFile(cc.config.KeyFilePath)
+		if err != nil {
+			return err
+		}
+		if err := cc.rekey(services.KeySourceKeyFile, secret); err != nil {
+			return err
+		}
+	}
+
+	token, _ := cc.changeToken()
+	formats, err := cc.backend.Formats()
+	if err != nil {
+		return nil
+	}
+	cc.lastToken = token
+	if marker, _ := cc.privacyFilter.MarkerAction(formats); marker == models.PrivacySkip {
+		return nil
+	}
+
+	if services.HasImageFormat(formats) {
+		if b, err := cc.backend.ReadImage(); err == nil && len(b) > 0 {
+			cc.lastImgHash = services.GetImageHash(b)
 		}
 	} else {
-		if txt, err := cc.clipboardService.ReadClipboardText(); err == nil {
+		if txt, err := cc.backend.ReadText(); err == nil {
 			cc.lastText = strings.TrimSpace(txt)
 		}
 	}
@@ -45,28 +210,78 @@ func (cc *ClipboardController) Initialize() error {
 	return nil
 }
 
+func (cc *ClipboardController) Shutdown() error {
+	cc.thumbnails.Close()
+	return cc.historyService.Close()
+}
+
 func (cc *ClipboardController) PollClipboard() *models.ClipboardItem {
-	loc := utils.GetTaipeiLocation()
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	// 內容沒變就不讀取，大圖片尤其昂貴
+	token, changed := cc.changeToken()
+	if !changed {
+		return nil
+	}
+	item, pending, read := cc.capture()
+	if pending != nil {
+		// 解碼與轉檔很慢，期間放開鎖，不擋住設定與清理
+		config := cc.config.Images
+		cc.mu.Unlock()
+		img, err := services.NormalizeImage(pending.data, config)
+		cc.mu.Lock()
+		if err != nil {
+			log.Printf("Failed to normalize clipboard image: %v", err)
+		} else if item = cc.storeImage(img, pending.marker); item == nil {
+			// 圖片存不下來時改記文字
+			item, read = cc.captureText(pending.marker, read)
+		}
+	}
+	// 讀取失敗時不記下 token，下次訊號會再試
+	if read {
+		cc.lastToken = token
+	}
+	return item
+}
+
+// pendingImage is a new clipboard image that capture read but has not yet
+// normalized or stored.
+type pendingImage struct {
+	data   []byte
+	marker models.PrivacyAction
+}
+
+// capture reads the clipboard and records anything new. A new image is
+// returned as pending instead, for the caller to normalize without holding
+// cc.mu. read is false when the clipboard could not be read, so the same
+// change is tried again.
+func (cc *ClipboardController) capture() (item *models.ClipboardItem, pending *pendingImage, read bool) {
+	formats, err := cc.backend.Formats()
+	if err != nil {
+		return nil, nil, false
+	}
+
+	// 密碼管理器標記為隱藏/暫時的內容，依設定處理
+	marker, _ := cc.privacyFilter.MarkerAction(formats)
+	if marker == models.PrivacySkip {
+		cc.lastText = ""
+		cc.lastImgHash = ""
+		return nil, nil, true
+	}
 	
 	// 首先檢查圖片
-	if cc.clipboardService.HasImageInClipboard() {
-		if b, err := cc.clipboardService.ReadClipboardImage(); err == nil && len(b) > 0 {
-			currentHash := cc.clipboardService.GetImageHash(b)
+	read = true
+	if services.HasImageFormat(formats) && marker != models.PrivacyMask {
+		if b, err := cc.backend.ReadImage(); err != nil || len(b) == 0 {
+			read = false
+		} else {
+			currentHash := services.GetImageHash(b)
 			if currentHash != cc.lastImgHash {
 				cc.lastImgHash = currentHash
 				// 重置文字追蹤，因為現在是圖片
 				cc.lastText = ""
-				timestamp := utils.FormatTimestamp(time.Now(), loc)
-				
-				if path, err := cc.fileService.SaveImage(b, timestamp); err == nil {
-					item := models.NewImageItem(path)
-					item.Timestamp = time.Now()
-					
-					if err := cc.historyService.AddItem(item); err == nil {
-						cc.historyService.MaintainLimit()
-						return item
-					}
-				}
+				return nil, &pendingImage{data: b, marker: marker}, true
 			}
 		}
 	} else {
@@ -74,56 +289,404 @@ func (cc *ClipboardController) PollClipboard() *models.ClipboardItem {
 		cc.lastImgHash = ""
 	}
 
-	// 然後檢查文字（無論是否有圖片都要檢查）
-	if txt, err := cc.clipboardService.ReadClipboardText(); err == nil {
-		normalized := strings.TrimSpace(txt)
-		if normalized != "" && normalized != cc.lastText {
-			cc.lastText = normalized
-			// 重置圖片追蹤，因為現在是文字
-			cc.lastImgHash = ""
-			item := models.NewTextItem(txt)
-			
-			if err := cc.historyService.AddItem(item); err == nil {
-				cc.historyService.MaintainLimit()
+	item, read = cc.captureText(marker, read)
+	return item, nil, read
+}
+
+// storeImage saves a normalized capture and adds it to the history, or
+// collapses it into a near duplicate. It returns nil when the image could not
+// be stored.
+func (cc *ClipboardController) storeImage(img *services.NormalizedImage, marker models.PrivacyAction) *models.ClipboardItem {
+	path, err := cc.historyService.SaveImage(img.Data, img.Ext)
+	if err != nil {
+		return nil
+	}
+	item := models.NewImageItem(path)
+	item.Timestamp = time.Now()
+	item.Original = &img.Original
+	item.ExpiresAt = cc.privacyFilter.ApplyMarker(services.PrivacyDecision{Action: models.PrivacyAllow}, marker, item.Timestamp).ExpiresAt
+	item.PHash = img.PHash
+
+	// 與剛才的截圖幾乎相同時，取代它而不是新增一筆
+	if cc.config.Images.NearDuplicates == models.NearDuplicatesCollapse {
+		if previous := services.FindNearDuplicate(cc.historyService.GetItems(), item, cc.config.Images); previous != nil {
+			if err := cc.historyService.Supersede(previous.ID, item); err == nil {
 				return item
 			}
+			return nil
 		}
 	}
+	
+	if err := cc.historyService.AddItem(item); err != nil {
+		return nil
+	}
+	cc.historyService.MaintainLimit()
+	return item
+}
 
-	return nil
+// captureText reads and records the clipboard text. read is passed through
+// unless the text cannot be read.
+func (cc *ClipboardController) captureText(marker models.PrivacyAction, read bool) (*models.ClipboardItem, bool) {
+	// 然後檢查文字（無論是否有圖片都要檢查）
+	txt, err := cc.backend.ReadText()
+	if err != nil {
+		return nil, false
+	}
+	normalized := strings.TrimSpace(txt)
+	if normalized != "" && normalized != cc.lastText {
+		cc.lastText = normalized
+		// 重置圖片追蹤，因為現在是文字
+		cc.lastImgHash = ""
+
+		// 隱私過濾：偵測到機密時略過、遮蔽或設定自動過期
+		decision := cc.privacyFilter.Inspect(txt, time.Now())
+		decision = cc.privacyFilter.ApplyMarker(decision, marker, time.Now())
+		if decision.Action == models.PrivacySkip {
+			return nil, read
+		}
+		item := models.NewTextItem(decision.Text)
+		item.ExpiresAt = decision.ExpiresAt
+		
+		if err := cc.historyService.AddItem(item); err == nil {
+			cc.historyService.MaintainLimit()
+			return item, read
+		}
+	}
+
+	return nil, read
+}
+
+// changeToken returns the backend's change token, when it offers one, and
+// whether it differs from the last one recorded. Without a token every call
+// counts as a change. The caller records the token once the clipboard was
+// read.
+func (cc *ClipboardController) changeToken() (string, bool) {
+	tb, ok := cc.backend.(services.ChangeTokenBackend)
+	if !ok {
+		return "", true
+	}
+	token, err := tb.ChangeToken()
+	if err != nil {
+		return "", true
+	}
+	return token, token != cc.lastToken
+}
+
+// Watch captures the clipboard each time watcher signals a change and hands
+// new items to onNew, until the watcher stops or stop is closed. A polling
+// watcher is told whether anything was new so it can back off.
+func (cc *ClipboardController) Watch(watcher services.ClipboardWatcher, stop <-chan struct{}, onNew func(*models.ClipboardItem)) {
+	reporter, _ := watcher.(interface{ Report(changed bool) })
+	if poller, ok := watcher.(interface{ SetInterval(time.Duration) }); ok {
+		cc.mu.Lock()
+		cc.poller = poller
+		cc.mu.Unlock()
+	}
+	for {
+		select {
+		case _, ok := <-watcher.Changes():
+			if !ok {
+				return
+			}
+			item := cc.PollClipboard()
+			if item != nil {
+				onNew(item)
+			}
+			if reporter != nil {
+				reporter.Report(item != nil)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// PurgeExpired removes items whose expiry has passed, such as secrets stored
+// with an auto-expiry, or that a retention rule no longer keeps, and returns
+// them so the view can drop them too.
+func (cc *ClipboardController) PurgeExpired() []*models.ClipboardItem {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	removed, _ := cc.historyService.RemoveExpired(cc.retention, time.Now())
+	return removed
 }
 
 func (cc *ClipboardController) CopyItemToClipboard(item *models.ClipboardItem) error {
 	if item.Type == models.ClipImage {
 		if cc.fileService.ImageExists(item.FilePath) {
-			return cc.clipboardService.CopyImageToClipboard(item.FilePath)
+			data, err := cc.fileService.ReadImage(item.FilePath)
+			if err != nil {
+				return err
+			}
+			return cc.backend.WriteImage(data)
 		}
 		return nil
 	}
-	return cc.clipboardService.CopyTextToClipboard(item.Content)
+	return cc.backend.WriteText(item.Content)
+}
+
+// UpdateSettings validates edited settings, writes them to the settings file
+// and applies them.
+func (cc *ClipboardController) UpdateSettings(updated *models.AppConfig) error {
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+	if _, _, err := compileRules(updated); err != nil {
+		return err
+	}
+	if err := updated.Save(); err != nil {
+		return err
+	}
+	return cc.ApplySettings(updated)
+}
+
+// ApplySettings switches to a new configuration while running. The history
+// size, polling interval, display, privacy and retention rules take effect
+// immediately; storage locations and the clipboard backend keep their
+// startup values until the next start. Views read the configuration without
+// locking, so call it on the UI thread.
+func (cc *ClipboardController) ApplySettings(updated *models.AppConfig) error {
+	privacyFilter, retention, err := compileRules(updated)
+	if err != nil {
+		return err
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	applied := updated.Clone()
+	applied.CopyStartupFields(cc.config)
+	if cc.poller != nil && applied.PollingInterval != cc.config.PollingInterval {
+		cc.poller.SetInterval(time.Duration(applied.PollingInterval) * time.Millisecond)
+	}
+	*cc.config = *applied
+	cc.privacyFilter = privacyFilter
+	cc.retention = retention
+	cc.historyService.SetMaxItems(applied.MaxHistoryItems)
+	return nil
+}
+
+func compileRules(config *models.AppConfig) (*services.PrivacyFilter, *services.RetentionPolicy, error) {
+	privacyFilter, err := services.NewPrivacyFilter(config.Privacy)
+	if err != nil {
+		return nil, nil, err
+	}
+	retention, err := services.NewRetentionPolicy(config.Retention, config.Location())
+	if err != nil {
+		return nil, nil, err
+	}
+	return privacyFilter, retention, nil
+}
+
+// lockVerifier is the key info the app lock checks passphrases against: the
+// encryption passphrase when there is one, a separate lock passphrase
+// otherwise.
+func (cc *ClipboardController) lockVerifier() *services.KeyInfo {
+	if cc.keyInfo != nil && cc.keyInfo.Source == services.KeySourcePassphrase {
+		return cc.keyInfo
+	}
+	return cc.lockInfo
+}
+
+// LockConfigured reports whether the app lock has a passphrase to unlock it.
+func (cc *ClipboardController) LockConfigured() bool {
+	return cc.lockVerifier() != nil
+}
+
+// SetLockPassphrase sets the app lock passphrase used while the history is
+// not encrypted with a passphrase.
+func (cc *ClipboardController) SetLockPassphrase(passphrase string) error {
+	if passphrase == "" {
+		return errors.New("passphrase must not be empty")
+	}
+	lockInfo, _, err := services.CreateKeyInfo(services.KeySourcePassphrase, []byte(passphrase))
+	if err != nil {
+		return err
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if err := services.SaveKeyInfo(cc.config.LockInfoPath, lockInfo); err != nil {
+		return err
+	}
+	cc.lockInfo = lockInfo
+	return nil
+}
+
+func (cc *ClipboardController) VerifyLockPassphrase(passphrase string) error {
+	verifier := cc.lockVerifier()
+	if verifier == nil {
+		return nil
+	}
+	_, err := verifier.Verify([]byte(passphrase))
+	return err
+}
+
+// LoadImage returns the decrypted data of an image item.
+func (cc *ClipboardController) LoadImage(item *models.ClipboardItem) ([]byte, error) {
+	return cc.fileService.ReadImage(item.FilePath)
+}
+
+// NearDuplicateGroups maps the ID of every image with near-duplicates to its
+// group when the settings ask for grouping, and returns nil otherwise.
+func (cc *ClipboardController) NearDuplicateGroups() map[string][]*models.ClipboardItem {
+	images := cc.config.Images
+	if images.NearDuplicates != models.NearDuplicatesGroup {
+		return nil
+	}
+	return services.GroupNearDuplicates(cc.historyService.GetItems(), images)
+}
+
+// KeepBestNearDuplicate removes the near-duplicates of the item with the
+// given ID except the best capture and pinned ones. It returns the kept item
+// and the removed ones.
+func (cc *ClipboardController) KeepBestNearDuplicate(id string) (*models.ClipboardItem, []*models.ClipboardItem, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	group := cc.NearDuplicateGroups()[id]
+	if len(group) == 0 {
+		return nil, nil, errors.New("no near-duplicates")
+	}
+	best := services.BestOfGroup(group)
+	var removed []*models.ClipboardItem
+	for _, item := range group {
+		if item.ID == best.ID || item.Pinned {
+			continue
+		}
+		if err := cc.historyService.RemoveItem(item.ID); err != nil {
+			return best, removed, err
+		}
+		removed = append(removed, item)
+	}
+	return best, removed, nil
+}
+
+// Thumbnail returns the thumbnail of an image item if it is ready, and
+// otherwise calls ready from another goroutine once it is.
+func (cc *ClipboardController) Thumbnail(item *models.ClipboardItem, ready func()) image.Image {
+	return cc.thumbnails.Get(item, ready)
 }
 
 func (cc *ClipboardController) GetHistoryItems() []*models.ClipboardItem {
 	return cc.historyService.GetItems()
 }
 
-func (cc *ClipboardController) GetHistoryItem(index int) *models.ClipboardItem {
-	return cc.historyService.GetItem(index)
+func (cc *ClipboardController) GetHistoryItem(id string) *models.ClipboardItem {
+	return cc.historyService.GetItem(id)
+}
+
+// SearchHistory returns the items whose text matches the query, newest first.
+func (cc *ClipboardController) SearchHistory(query string, mode services.SearchMode) []*models.ClipboardItem {
+	if strings.TrimSpace(query) == "" {
+		return cc.historyService.GetItems()
+	}
+	return cc.historyService.Search(query, mode)
+}
+
+// QueryHistory evaluates a query string (see services.Query) and returns the
+// matching items together with the parsed query for highlighting.
+func (cc *ClipboardController) QueryHistory(query string) ([]*models.ClipboardItem, *services.Query, error) {
+	q, err := services.ParseQuery(query, cc.config.Location())
+	if err != nil {
+		return nil, nil, err
+	}
+	if q.IsEmpty() {
+		return cc.historyService.GetItems(), q, nil
+	}
+	return cc.historyService.Query(q), q, nil
+}
+
+func (cc *ClipboardController) RemoveHistoryItem(id string) error {
+	return cc.historyService.RemoveItem(id)
+}
+
+func (cc *ClipboardController) UpdateHistoryItem(id string, newContent string) error {
+	return cc.historyService.UpdateItem(id, newContent)
 }
 
-func (cc *ClipboardController) RemoveHistoryItem(index int) error {
-	return cc.historyService.RemoveItem(index)
+func (cc *ClipboardController) SetItemLabels(id string, collection string, tags []string) error {
+	return cc.historyService.SetLabels(id, collection, tags)
 }
 
-func (cc *ClipboardController) UpdateHistoryItem(index int, newContent string) error {
-	return cc.historyService.UpdateItem(index, newContent)
+func (cc *ClipboardController) GetCollections() []string {
+	return cc.historyService.Collections()
 }
 
-func (cc *ClipboardController) ClearHistory() error {
-	cc.clipboardService.ClearSystemClipboard()
+// ExportCollection writes the text items of a collection, optionally narrowed
+// to a tag, in the history file format so they can be shared and imported.
+// An empty collection exports every item carrying the tag.
+func (cc *ClipboardController) ExportCollection(collection, tag string) ([]byte, error) {
+	items := cc.historyService.GetItems()
+	records := make([]models.HistoryRecord, 0)
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		if item.Type != models.ClipText {
+			continue // image files are not portable
+		}
+		if collection != "" && item.Collection != collection {
+			continue
+		}
+		if tag != "" && !item.HasTag(tag) {
+			continue
+		}
+		records = append(records, item.ToRecord())
+	}
+	return services.EncodeHistoryRecords(records)
+}
+
+// ImportCollection adds the text items of an exported collection, skipping
+// items that are already in the history, and returns how many were added.
+func (cc *ClipboardController) ImportCollection(data []byte) (int, error) {
+	records, err := services.DecodeHistoryRecords(bytes.NewReader(data), "collection")
+	if err != nil {
+		return 0, err
+	}
+
+	var items []*models.ClipboardItem
+	for _, rec := range records {
+		if rec.ID != "" && cc.historyService.GetItem(rec.ID) != nil {
+			continue
+		}
+		item, err := models.ItemFromRecord(rec)
+		if err != nil {
+			return 0, err
+		}
+		if item.Type != models.ClipText {
+			continue
+		}
+		items = append(items, item)
+	}
+	return len(items), cc.historyService.ImportItems(items)
+}
+
+func (cc *ClipboardController) SetItemPinned(id string, pinned bool) error {
+	return cc.historyService.SetPinned(id, pinned)
+}
+
+// ClearHistory removes every unpinned item, or everything when includePinned
+// is set.
+func (cc *ClipboardController) ClearHistory(includePinned bool) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.backend.Clear()
 	cc.lastText = ""
 	cc.lastImgHash = ""
-	return cc.historyService.Clear()
+	return cc.historyService.Clear(includePinned)
+}
+
+// Fsck loads the history without touching the clipboard and checks the
+// image blobs against it. Call it after OpenVault instead of Initialize.
+func (cc *ClipboardController) Fsck(prune bool) (*services.FsckReport, error) {
+	// 只檢查時不寫入任何資料；清除前才需要完整載入與遷移
+	load := cc.historyService.LoadReadOnly
+	if prune {
+		load = cc.historyService.Load
+	}
+	if err := load(); err != nil {
+		return nil, err
+	}
+	return cc.historyService.Fsck(prune)
 }
 
 func (cc *ClipboardController) ExportHistory() string {
@@ -132,7 +695,7 @@ func (cc *ClipboardController) ExportHistory() string {
 	
 	for i := len(items) - 1; i >= 0; i-- {
 		item := items[i]
-		timestamp := utils.FormatTimestamp(item.Timestamp, utils.GetTaipeiLocation())
+		timestamp := utils.FormatTimestamp(item.Timestamp, cc.config.Location())
 		
 		if item.Type == models.ClipImage {
 			lines[len(items)-1-i] = timestamp + "\t" + item.FilePath + "\t" + item.Type.String()
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"clipmini/models"
+	"clipmini/services"
+)
+
+func newTestController(t *testing.T) (*ClipboardController, *services.MemoryClipboardBackend, *models.AppConfig) {
+	t.Helper()
+	dir := t.TempDir()
+	config, err := models.LoadAppConfig(models.ResolveAppDirs(models.AppDirs{Data: dir, Config: dir, Cache: dir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := services.NewMemoryClipboardBackend()
+	cc := NewClipboardController(config, backend)
+	t.Cleanup(func() { cc.Shutdown() })
+	if _, err := cc.OpenVault(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	return cc, backend, config
+}
+
+// TestPollClipboardConcurrentEdits captures while the UI reads, edits and
+// deletes the history; run it with -race.
+func TestPollClipboardConcurrentEdits(t *testing.T) {
+	cc, backend, _ := newTestController(t)
+
+	const rounds = 50
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < rounds; i++ {
+			backend.WriteText(fmt.Sprintf("copied %d", i))
+			cc.PollClipboard()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for i, item := range cc.GetHistoryItems() {
+				switch i % 3 {
+				case 0:
+					cc.UpdateHistoryItem(item.ID, item.Content+" edited")
+				case 1:
+					cc.SetItemPinned(item.ID, !item.Pinned)
+				default:
+					cc.RemoveHistoryItem(item.ID)
+				}
+			}
+			cc.SearchHistory("copied", services.SearchSubstring)
+		}
+	}()
+	wg.Wait()
+
+	if item := cc.PollClipboard(); item != nil {
+		t.Fatalf("unchanged clipboard captured again: %q", item.Content)
+	}
+}
+
+// TestPollClipboardReplaysScript plays a clipboard script through the memory
+// backend and checks what ends up in the history and the image directory.
+func TestPollClipboardReplaysScript(t *testing.T) {
+	cc, backend, config := newTestController(t)
+
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 320, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 320; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 0x80, 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "shot.png"), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := `[
+		{"text": "hello"},
+		{"text": "hello"},
+		{"image": "` + filepath.ToSlash(filepath.Join(dir, "shot.png")) + `"},
+		{"text": "world"}
+	]`
+	scriptPath := filepath.Join(dir, "script.json")
+	if err := os.WriteFile(scriptPath, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	steps, err := services.LoadClipboardScript(scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One step at a time, so no change is coalesced before it is polled
+	for _, step := range steps {
+		for err := range backend.Play([]services.ScriptStep{step}, nil) {
+			t.Fatal(err)
+		}
+		cc.PollClipboard()
+	}
+
+	items := cc.GetHistoryItems()
+	if len(items) != 3 {
+		t.Fatalf("got %d history items, want 3", len(items))
+	}
+	if items[0].Type != models.ClipText || items[0].Content != "world" {
+		t.Errorf("newest item = %v %q, want text \"world\"", items[0].Type, items[0].Content)
+	}
+	if items[2].Type != models.ClipText || items[2].Content != "hello" {
+		t.Errorf("oldest item = %v %q, want text \"hello\"", items[2].Type, items[2].Content)
+	}
+
+	shot := items[1]
+	if shot.Type != models.ClipImage {
+		t.Fatalf("middle item is %v, want an image", shot.Type)
+	}
+	if !strings.HasPrefix(shot.FilePath, config.ImageDirPath+string(filepath.Separator)) {
+		t.Fatalf("image saved to %s, outside %s", shot.FilePath, config.ImageDirPath)
+	}
+	if _, err := os.Stat(shot.FilePath); err != nil {
+		t.Fatalf("image file: %v", err)
+	}
+	data, err := cc.LoadImage(shot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("saved image does not decode: %v", err)
+	}
+	if saved.Width != 320 || saved.Height != 200 {
+		t.Errorf("saved image is %d×%d, want 320×200", saved.Width, saved.Height)
+	}
+}
@@ -1,26 +1,66 @@
 package main
 
 import (
+	"bufio"
 	_ "embed"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/widget"
 
 	"clipmini/controllers"
 	"clipmini/models"
+	"clipmini/services"
 	"clipmini/views"
 )
 
 var notoTC []byte
 
+const janitorInterval = 5 * time.Second
+
 func main() {
-	config := models.NewAppConfig()
+	var dirs models.AppDirs
+	flag.StringVar(&dirs.Data, "data-dir", "", "directory for the history, images and keys (env CLIPMINI_DATA_DIR)")
+	flag.StringVar(&dirs.Config, "config-dir", "", "directory of config.json (env CLIPMINI_CONFIG_DIR)")
+	flag.StringVar(&dirs.Cache, "cache-dir", "", "directory for rebuildable caches (env CLIPMINI_CACHE_DIR)")
+	fsck := flag.Bool("fsck", false, "check stored images against the history and exit")
+	fsckPrune := flag.Bool("fsck-prune", false, "like -fsck, and delete images no item references")
+	headless := flag.Bool("headless", false, "capture the clipboard without a window until interrupted, e.g. in CI with the memory backend")
+	flag.Parse()
 
-	clipboardController := controllers.NewClipboardController(config)
-	if err := clipboardController.Initialize(); err != nil {
-		log.Fatal("Failed to initialize clipboard controller:", err)
+	config, err := models.LoadAppConfig(models.ResolveAppDirs(dirs))
+	if err != nil {
+		log.Fatal("Failed to load settings:", err)
+	}
+	if err := services.MigrateDataDir(config); err != nil {
+		log.Fatal("Failed to move data to ", config.LogDirPath, ": ", err)
+	}
+	if *fsck || *fsckPrune {
+		os.Exit(runFsck(config, *fsckPrune))
+	}
+
+	backend, err := services.NewClipboardBackend(config)
+	if err != nil {
+		log.Fatal("Failed to select clipboard backend:", err)
+	}
+	log.Printf("Using %s clipboard backend", backend.Name())
+	if *headless {
+		os.Exit(runHeadless(config, backend))
+	}
+
+	clipboardController := controllers.NewClipboardController(config, backend)
+	locked, err := clipboardController.OpenVault()
+	if err != nil {
+		log.Fatal("Failed to open encrypted history:", err)
 	}
 
 	fyneApp := app.NewWithID("superClip")
@@ -34,32 +74,183 @@ func main() {
 	window := fyneApp.NewWindow("超吉貼")
 	window.Resize(fyne.NewSize(820, 520))
 
-	mainView := views.NewMainView(clipboardController, config)
-	mainView.Initialize(window)
+	configWatcher := services.NewConfigWatcher(config)
+	defer configWatcher.Close()
+
+	stopChannel := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() { stopOnce.Do(func() { close(stopChannel) }) }
+	// workers are the capture and janitor goroutines, which use the
+	// controller until they see stopChannel closed
+	var workers sync.WaitGroup
+	start := func() {
+		if err := clipboardController.Initialize(); err != nil {
+			log.Fatal("Failed to initialize clipboard controller:", err)
+		}
 
-	window.SetContent(mainView.GetContent())
+		mainView := views.NewMainView(clipboardController, config)
+		mainView.Initialize(window)
+		window.SetContent(mainView.GetContent())
+
+		watcher := services.NewClipboardWatcher(backend, time.Duration(config.PollingInterval)*time.Millisecond)
+		configWatcher.Subscribe(func(_, updated *models.AppConfig) {
+			fyne.DoAndWait(func() {
+				if err := clipboardController.ApplySettings(updated); err != nil {
+					log.Printf("Failed to apply settings: %v", err)
+				}
+			})
+		})
+		configWatcher.Subscribe(mainView.OnConfigChanged)
+		if err := configWatcher.Start(); err != nil {
+			log.Printf("Settings hot reload disabled: %v", err)
+		}
+
+		workers.Add(2)
+		go func() {
+			defer workers.Done()
+			defer watcher.Close()
+			clipboardController.Watch(watcher, stopChannel, mainView.OnNewClipboardItem)
+		}()
+		go func() {
+			defer workers.Done()
+			runJanitor(clipboardController, mainView, stopChannel)
+		}()
+	}
+
+	if locked {
+		window.SetContent(widget.NewLabel("🔐 紀錄已加密"))
+		views.ShowUnlockPrompt(window, clipboardController.Unlock, start)
+	} else {
+		start()
+	}
+
+	window.SetCloseIntercept(func() {
+		stop()
+		window.Close()
+	})
+
+	window.ShowAndRun()
+
+	// Capture and the janitor must be done with the history before it closes
+	stop()
+	workers.Wait()
+	clipboardController.Shutdown()
+}
+
+// runJanitor applies expiry and the retention rules in the background, and
+// checks whether the window should lock.
+func runJanitor(clipboardController *controllers.ClipboardController, mainView *views.MainView, stopChannel chan struct{}) {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if expired := clipboardController.PurgeExpired(); len(expired) > 0 {
+				mainView.OnItemsRemoved(expired)
+			}
+			mainView.RefreshRelativeTimes(time.Now())
+			mainView.CheckIdle(time.Now())
+		case <-stopChannel:
+			return
+		}
+	}
+}
+
+// runHeadless captures the clipboard like the app does, without a window,
+// and logs every new item until SIGINT or SIGTERM. An encrypted history
+// needs a key file, there is no way to ask for a passphrase.
+func runHeadless(config *models.AppConfig, backend services.ClipboardBackend) int {
+	clipboardController := controllers.NewClipboardController(config, backend)
+	defer clipboardController.Shutdown()
+	locked, err := clipboardController.OpenVault()
+	if err != nil {
+		log.Print("Failed to open encrypted history: ", err)
+		return 2
+	}
+	if locked {
+		log.Print("Failed to unlock history: a key file is needed without a window")
+		return 2
+	}
+	if err := clipboardController.Initialize(); err != nil {
+		log.Print("Failed to initialize clipboard controller: ", err)
+		return 2
+	}
 
 	stopChannel := make(chan struct{})
+	stop := sync.OnceFunc(func() { close(stopChannel) })
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		ticker := time.NewTicker(time.Duration(config.PollingInterval) * time.Millisecond)
-		defer ticker.Stop()
+		<-signals
+		stop()
+	}()
 
+	var janitor sync.WaitGroup
+	janitor.Add(1)
+	go func() {
+		defer janitor.Done()
+		ticker := time.NewTicker(janitorInterval)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				if newItem := clipboardController.PollClipboard(); newItem != nil {
-					mainView.OnNewClipboardItem(newItem)
-				}
+				clipboardController.PurgeExpired()
 			case <-stopChannel:
 				return
 			}
 		}
 	}()
 
-	window.SetCloseIntercept(func() {
-		close(stopChannel)
-		window.Close()
+	watcher := services.NewClipboardWatcher(backend, time.Duration(config.PollingInterval)*time.Millisecond)
+	defer watcher.Close()
+	clipboardController.Watch(watcher, stopChannel, func(item *models.ClipboardItem) {
+		log.Printf("Captured %s item %s", item.Type, item.ID)
 	})
+	// Watch also returns when the watcher gives up
+	stop()
+	janitor.Wait()
+	return 0
+}
 
-	window.ShowAndRun()
+// runFsck reports images that are missing or orphaned and returns the exit
+// status: 1 when something is wrong, 2 when the check could not run.
+func runFsck(config *models.AppConfig, prune bool) int {
+	clipboardController := controllers.NewClipboardController(config, nil)
+	defer clipboardController.Shutdown()
+	locked, err := clipboardController.OpenVault()
+	if err != nil {
+		log.Print("Failed to open encrypted history: ", err)
+		return 2
+	}
+	if locked {
+		fmt.Fprint(os.Stderr, "Passphrase: ")
+		passphrase, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err := clipboardController.Unlock(strings.TrimRight(passphrase, "\r\n")); err != nil {
+			log.Print("Failed to unlock history: ", err)
+			return 2
+		}
+	}
+
+	report, err := clipboardController.Fsck(prune)
+	if err != nil {
+		log.Print("Failed to check images: ", err)
+		return 2
+	}
+
+	for _, item := range report.Missing {
+		fmt.Printf("missing\t%s\t%s\n", item.ID, item.FilePath)
+	}
+	for _, path := range report.Orphaned {
+		if prune {
+			fmt.Printf("removed\t%s\n", path)
+		} else {
+			fmt.Printf("orphaned\t%s\n", path)
+		}
+	}
+	fmt.Printf("%d missing, %d orphaned\n", len(report.Missing), len(report.Orphaned))
+	if len(report.Missing) > 0 || (len(report.Orphaned) > 0 && !prune) {
+		return 1
+	}
+	return 0
 }
@@ -1,13 +1,23 @@
 package models
 
-import "time"
+import (
+	"sort"
+	"strings"
+	"time"
+)
 
 type ClipboardItem struct {
-	ID        string
-	Timestamp time.Time
-	Content   string
-	Type      ClipType
-	FilePath  string // for images
+	ID         string
+	Timestamp  time.Time
+	Content    string
+	Type       ClipType
+	FilePath   string // for images
+	Pinned     bool   // pinned items survive trimming and clearing
+	Collection string // named collection, empty when unfiled
+	Tags       []string
+	ExpiresAt  time.Time  // zero when the item never expires
+	Original   *ImageInfo // format and size of a captured image before it was converted
+	PHash      string     // perceptual hash of an image, empty when unknown
 }
 
 type ClipType int
@@ -30,6 +40,7 @@ func (t ClipType) String() string {
 
 func NewTextItem(content string) *ClipboardItem {
 	return &ClipboardItem{
+		ID:        NewItemID(),
 		Timestamp: time.Now(),
 		Content:   content,
 		Type:      ClipText,
@@ -38,8 +49,50 @@ func NewTextItem(content string) *ClipboardItem {
 
 func NewImageItem(filePath string) *ClipboardItem {
 	return &ClipboardItem{
+		ID:        NewItemID(),
 		Timestamp: time.Now(),
 		Type:      ClipImage,
 		FilePath:  filePath,
 	}
-}
\ No newline at end of file
+}
+
+func (item *ClipboardItem) IsExpired(now time.Time) bool {
+	return !item.ExpiresAt.IsZero() && !now.Before(item.ExpiresAt)
+}
+
+func (item *ClipboardItem) HasTag(tag string) bool {
+	for _, t := range item.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeTags trims, de-duplicates (case-insensitively) and sorts tags.
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, t := range tags {
+		t = NormalizeLabel(t)
+		if t == "" || seen[strings.ToLower(t)] {
+			continue
+		}
+		seen[strings.ToLower(t)] = true
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// NormalizeLabel cleans up a tag or collection name. Quotes and commas are
+// dropped since they delimit labels in queries and the tag editor.
+func NormalizeLabel(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '"' || r == ',' || r == '\n' || r == '\t' {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
@@ -1,35 +1,274 @@
 package models
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"clipmini/utils"
 )
 
 const (
 	DefaultMaxHistoryItems  = 30
 	DefaultMaxDisplayLength = 50
 	DefaultPollingInterval  = 800 // milliseconds
+	DefaultClipboardBackend = "auto"
+	DefaultHistoryStore     = "jsonl"
+	DefaultLockAfterSeconds = 300
+	DefaultTimezone         = "Asia/Taipei"
+	DefaultTimestampFormat  = "2006-01-02 15:04:05"
+
+	MinPollingInterval = 100 // milliseconds
 )
 
+// AppConfig is loaded from config.json in the config directory. Fields
+// tagged "-" are derived or come from the environment and are never saved.
 type AppConfig struct {
-	MaxHistoryItems  int
-	MaxDisplayLength int
-	PollingInterval  int
-	LogDirPath       string
-	LogFilePath      string
-	ImageDirPath     string
+	MaxHistoryItems    int             `json:"max_history_items"` // 0 keeps every item
+	MaxDisplayLength   int             `json:"max_display_length"`
+	PollingInterval    int             `json:"polling_interval_ms"`
+	LogDirPath         string          `json:"data_dir,omitempty"`
+	LogFilePath        string          `json:"history_file,omitempty"`
+	LegacyLogPath      string          `json:"-"`
+	HistoryStore       string          `json:"history_store"` // jsonl or bolt
+	HistoryDBPath      string          `json:"history_db,omitempty"`
+	ImageDirPath       string          `json:"image_dir,omitempty"`
+	KeyInfoPath        string          `json:"-"`                   // key derivation settings, present once encryption is enabled
+	KeyFilePath        string          `json:"-"`                   // unlocks encryption without a passphrase prompt
+	LockInfoPath       string          `json:"-"`                   // app lock passphrase when the history is not encrypted
+	LockAfterSeconds   int             `json:"lock_after_seconds"`  // idle time before the window locks, 0 never locks
+	Timezone           string          `json:"timezone"`            // IANA name or Local
+	TimestampFormat    string          `json:"timestamp_format"`    // Go layout, e.g. 01/02 15:04
+	RelativeTimestamps bool            `json:"relative_timestamps"` // "3 分鐘前" in the list for the last week
+	ClipboardBackend   string          `json:"clipboard_backend"`   // auto, macos, linux or memory
+	MemoryScriptPath   string          `json:"-"`                   // clipboard script replayed by the memory backend
+	Images             ImageConfig     `json:"images"`
+	Privacy            PrivacyConfig   `json:"privacy"`
+	Retention          []RetentionRule `json:"retention"`
+	CacheDirPath       string          `json:"-"`
+	ConfigPath         string          `json:"-"`
+	Dirs               AppDirs         `json:"-"`
 }
 
+// NewAppConfig returns the defaults in the platform directories with
+// environment overrides applied.
 func NewAppConfig() *AppConfig {
-	home, _ := os.UserHomeDir()
-	logDir := filepath.Join(home, "Library", "Application Support", "ClipMini")
-	
-	return &AppConfig{
+	c := defaultAppConfig(ResolveAppDirs(AppDirs{}))
+	c.applyEnv()
+	return c
+}
+
+func defaultAppConfig(dirs AppDirs) *AppConfig {
+	c := &AppConfig{
 		MaxHistoryItems:  DefaultMaxHistoryItems,
 		MaxDisplayLength: DefaultMaxDisplayLength,
 		PollingInterval:  DefaultPollingInterval,
-		LogDirPath:       logDir,
-		LogFilePath:      filepath.Join(logDir, "history.txt"),
-		ImageDirPath:     filepath.Join(logDir, "images"),
+		HistoryStore:     DefaultHistoryStore,
+		LockAfterSeconds: DefaultLockAfterSeconds,
+		Timezone:         DefaultTimezone,
+		TimestampFormat:  DefaultTimestampFormat,
+		ClipboardBackend: DefaultClipboardBackend,
+		Images:           NewImageConfig(),
+		Privacy:          NewPrivacyConfig(),
+		CacheDirPath:     dirs.Cache,
+		ConfigPath:       dirs.ConfigFile(),
+		Dirs:             dirs,
+	}
+	c.SetDataDir(dirs.Data)
+	return c
+}
+
+// LoadAppConfig reads config.json in the config directory over the defaults,
+// writing the defaults there on first run. Environment variables still win
+// over the file, and so does a data directory given as a flag.
+func LoadAppConfig(dirs AppDirs) (*AppConfig, error) {
+	c := defaultAppConfig(dirs)
+	path := c.ConfigPath
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := c.Save(); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		defaultDir := c.LogDirPath
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		dir := c.LogDirPath
+		c.LogDirPath = defaultDir
+		// Older versions saved their hard-coded data directory; the data is
+		// moved to the platform one by services.MigrateDataDir.
+		if dir != "" && dir != LegacyDataDir() && !dirs.dataOverride {
+			c.SetDataDir(dir)
+		}
+	}
+
+	c.applyEnv()
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *AppConfig) applyEnv() {
+	c.HistoryStore = envOrDefault("CLIPMINI_HISTORY_STORE", c.HistoryStore)
+	c.ClipboardBackend = envOrDefault("CLIPMINI_CLIPBOARD_BACKEND", c.ClipboardBackend)
+	c.MemoryScriptPath = os.Getenv("CLIPMINI_MEMORY_SCRIPT")
+	c.KeyFilePath = os.Getenv("CLIPMINI_KEY_FILE")
+}
+
+// SetDataDir moves the data directory. Paths left empty or at their default
+// under the old directory follow it; paths set elsewhere are kept.
+func (c *AppConfig) SetDataDir(dir string) {
+	follow := func(path *string, name string) {
+		if *path == "" || *path == filepath.Join(c.LogDirPath, name) {
+			*path = filepath.Join(dir, name)
+		}
+	}
+	follow(&c.LogFilePath, "history.jsonl")
+	follow(&c.HistoryDBPath, "history.db")
+	follow(&c.ImageDirPath, "images")
+	c.LegacyLogPath = filepath.Join(dir, "history.txt")
+	c.KeyInfoPath = filepath.Join(dir, "keyinfo.json")
+	c.LockInfoPath = filepath.Join(dir, "lock.json")
+	c.LogDirPath = dir
+}
+
+// Location is the time zone timestamps are shown and parsed in.
+func (c *AppConfig) Location() *time.Location {
+	if loc, err := time.LoadLocation(c.Timezone); err == nil {
+		return loc
+	}
+	return time.Local
+}
+
+// Validate reports every invalid setting at once.
+func (c *AppConfig) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.MaxHistoryItems >= 0, "max_history_items must not be negative")
+	check(c.MaxDisplayLength > 0, "max_display_length must be positive")
+	check(c.PollingInterval >= MinPollingInterval, "polling_interval_ms must be at least %d", MinPollingInterval)
+	check(c.LockAfterSeconds >= 0, "lock_after_seconds must not be negative")
+	paths := []struct{ name, path string }{
+		{"data_dir", c.LogDirPath}, {"history_file", c.LogFilePath}, {"history_db", c.HistoryDBPath}, {"image_dir", c.ImageDirPath},
+	}
+	for _, p := range paths {
+		check(filepath.IsAbs(p.path), "%s must be an absolute path", p.name)
 	}
-}
\ No newline at end of file
+	check(c.HistoryStore == "jsonl" || c.HistoryStore == "bolt", "unknown history_store %q", c.HistoryStore)
+	switch c.ClipboardBackend {
+	case "auto", "macos", "linux", "memory":
+	default:
+		errs = append(errs, fmt.Errorf("unknown clipboard_backend %q", c.ClipboardBackend))
+	}
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		errs = append(errs, fmt.Errorf("timezone: %w", err))
+	}
+	check(strings.TrimSpace(c.TimestampFormat) != "", "timestamp_format must not be empty")
+
+	img := c.Images
+	check(img.Format == ImageFormatPNG || img.Format == ImageFormatJPEG, "images: format must be png or jpeg, not %q", img.Format)
+	check(img.JPEGQuality >= 1 && img.JPEGQuality <= 100, "images: jpeg_quality must be between 1 and 100")
+	check(img.MaxDimension >= 0, "images: max_dimension must not be negative")
+	check(img.MaxBytes >= 0, "images: max_bytes must not be negative")
+	check(img.NearDuplicates == NearDuplicatesOff || img.NearDuplicates == NearDuplicatesCollapse || img.NearDuplicates == NearDuplicatesGroup,
+		"images: near_duplicates must be off, collapse or group, not %q", img.NearDuplicates)
+	check(img.NearDuplicateDistance >= 0 && img.NearDuplicateDistance <= 64, "images: near_duplicate_distance must be between 0 and 64")
+	check(img.NearDuplicateWindow >= 0, "images: near_duplicate_window must not be negative")
+
+	p := c.Privacy
+	check(p.DefaultAction.Valid(), "privacy: unknown default_action %q", p.DefaultAction)
+	check(p.ExpireAfterSeconds > 0, "privacy: expire_after_seconds must be positive")
+	for _, r := range p.Rules {
+		check(r.Action == "" || r.Action.Valid(), "privacy rule %q: unknown action %q", r.Name, r.Action)
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			errs = append(errs, fmt.Errorf("privacy rule %q: %w", r.Name, err))
+		}
+	}
+	for marker, action := range p.MarkerPolicies {
+		check(action.Valid(), "privacy marker %q: unknown action %q", marker, action)
+	}
+	for _, r := range c.Retention {
+		check(r.MaxAgeSeconds > 0, "retention rule %q: max_age_seconds must be positive", r.Name)
+	}
+	return errors.Join(errs...)
+}
+
+// Save writes the settings file atomically. The data directory and the
+// paths under it are left out while at their default so they keep following
+// the platform directories.
+func (c *AppConfig) Save() error {
+	if err := os.MkdirAll(filepath.Dir(c.ConfigPath), 0o755); err != nil {
+		return err
+	}
+	saved := *c
+	if saved.LogDirPath == c.Dirs.Data {
+		saved.LogDirPath = ""
+	}
+	for _, p := range []struct {
+		path *string
+		name string
+	}{{&saved.LogFilePath, "history.jsonl"}, {&saved.HistoryDBPath, "history.db"}, {&saved.ImageDirPath, "images"}} {
+		if *p.path == filepath.Join(c.LogDirPath, p.name) {
+			*p.path = ""
+		}
+	}
+	data, err := json.MarshalIndent(&saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(c.ConfigPath, append(data, '\n'), 0o600)
+}
+
+// CopyStartupFields takes the settings that are only read at startup from
+// running, so a reload cannot move the open history under the app's feet.
+func (c *AppConfig) CopyStartupFields(running *AppConfig) {
+	c.LogDirPath = running.LogDirPath
+	c.LogFilePath = running.LogFilePath
+	c.LegacyLogPath = running.LegacyLogPath
+	c.HistoryStore = running.HistoryStore
+	c.HistoryDBPath = running.HistoryDBPath
+	c.ImageDirPath = running.ImageDirPath
+	c.KeyInfoPath = running.KeyInfoPath
+	c.KeyFilePath = running.KeyFilePath
+	c.LockInfoPath = running.LockInfoPath
+	c.ClipboardBackend = running.ClipboardBackend
+	c.MemoryScriptPath = running.MemoryScriptPath
+	c.CacheDirPath = running.CacheDirPath
+	c.Dirs = running.Dirs
+}
+
+// Clone returns a deep copy for editing.
+func (c *AppConfig) Clone() *AppConfig {
+	clone := *c
+	clone.Privacy.Rules = append([]PrivacyRule(nil), c.Privacy.Rules...)
+	clone.Privacy.MarkerPolicies = make(map[string]PrivacyAction, len(c.Privacy.MarkerPolicies))
+	for k, v := range c.Privacy.MarkerPolicies {
+		clone.Privacy.MarkerPolicies[k] = v
+	}
+	clone.Retention = append([]RetentionRule(nil), c.Retention...)
+	return &clone
+}
+
+func envOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
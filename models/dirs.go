@@ -0,0 +1,94 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const appDirName = "ClipMini"
+
+// AppDirs are the directories the app keeps its files in.
+type AppDirs struct {
+	Data   string // history, images and keys
+	Config string // config.json
+	Cache  string // files that can be rebuilt at any time
+
+	// dataOverride is set when Data came from a flag or the environment, in
+	// which case it wins over data_dir in the settings file.
+	dataOverride bool
+}
+
+// ResolveAppDirs returns the platform directories. Non-empty fields of flags
+// win, then CLIPMINI_DATA_DIR, CLIPMINI_CONFIG_DIR and CLIPMINI_CACHE_DIR.
+func ResolveAppDirs(flags AppDirs) AppDirs {
+	dirs := platformAppDirs()
+	for _, d := range []struct {
+		dst      *string
+		flag     string
+		env      string
+		override *bool
+	}{
+		{&dirs.Data, flags.Data, "CLIPMINI_DATA_DIR", &dirs.dataOverride},
+		{&dirs.Config, flags.Config, "CLIPMINI_CONFIG_DIR", nil},
+		{&dirs.Cache, flags.Cache, "CLIPMINI_CACHE_DIR", nil},
+	} {
+		dir := d.flag
+		if dir == "" {
+			dir = os.Getenv(d.env)
+		}
+		if dir == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		*d.dst = dir
+		if d.override != nil {
+			*d.override = true
+		}
+	}
+	return dirs
+}
+
+// ConfigFile is the settings file in the config directory.
+func (d AppDirs) ConfigFile() string {
+	return filepath.Join(d.Config, "config.json")
+}
+
+// platformAppDirs follows the XDG base directory spec on Linux and the BSDs,
+// and the usual Library folders on macOS.
+func platformAppDirs() AppDirs {
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "darwin":
+		support := filepath.Join(home, "Library", "Application Support", appDirName)
+		return AppDirs{Data: support, Config: support, Cache: filepath.Join(home, "Library", "Caches", appDirName)}
+	case "windows":
+		config, _ := os.UserConfigDir()
+		cache, _ := os.UserCacheDir()
+		return AppDirs{Data: filepath.Join(config, appDirName), Config: filepath.Join(config, appDirName), Cache: filepath.Join(cache, appDirName)}
+	default:
+		return AppDirs{
+			Data:   filepath.Join(xdgDir("XDG_DATA_HOME", filepath.Join(home, ".local", "share")), appDirName),
+			Config: filepath.Join(xdgDir("XDG_CONFIG_HOME", filepath.Join(home, ".config")), appDirName),
+			Cache:  filepath.Join(xdgDir("XDG_CACHE_HOME", filepath.Join(home, ".cache")), appDirName),
+		}
+	}
+}
+
+// xdgDir reads an XDG variable; the spec says relative paths are invalid and
+// must be ignored.
+func xdgDir(env, fallback string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	return fallback
+}
+
+// LegacyDataDir is where every version before platform directories kept its
+// data, whatever the platform.
+func LegacyDataDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "Library", "Application Support", appDirName)
+}
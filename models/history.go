@@ -1,40 +1,79 @@
 package models
 
 import (
-	"fmt"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
 
+// History is not safe for concurrent use, HistoryService guards it. Items
+// are never modified in place: edits swap in a changed copy, so slices
+// handed out by GetItems stay valid snapshots.
 type History struct {
 	Items       []*ClipboardItem
 	MaxItems    int
-	Location    *time.Location
 }
 
 func NewHistory(maxItems int) *History {
-	loc, _ := time.LoadLocation("Asia/Taipei")
 	return &History{
 		Items:    make([]*ClipboardItem, 0),
 		MaxItems: maxItems,
-		Location: loc,
 	}
 }
 
-func (h *History) Add(item *ClipboardItem) {
-	h.Items = append([]*ClipboardItem{item}, h.Items...)
-	
-	if len(h.Items) > h.MaxItems {
-		h.Items = h.Items[:h.MaxItems]
+// Add prepends the item and returns the oldest items pushed out by MaxItems.
+func (h *History) Add(item *ClipboardItem) []*ClipboardItem {
+	// Shift in place rather than copy into a new slice on every capture
+	h.Items = append(h.Items, nil)
+	copy(h.Items[1:], h.Items)
+	h.Items[0] = item
+	return h.Trim()
+}
+
+// Trim keeps the newest MaxItems unpinned items plus every pinned item and
+// returns the dropped ones. A MaxItems of 0 or less keeps every item.
+func (h *History) Trim() []*ClipboardItem {
+	if h.MaxItems <= 0 || len(h.Items) <= h.MaxItems {
+		return nil
+	}
+
+	var dropped []*ClipboardItem
+	kept := h.Items[:0]
+	unpinned := 0
+	for _, item := range h.Items {
+		if !item.Pinned {
+			unpinned++
+			if unpinned > h.MaxItems {
+				dropped = append(dropped, item)
+				continue
+			}
+		}
+		kept = append(kept, item)
 	}
+	h.Items = kept
+	return dropped
 }
 
+// GetItems returns a snapshot of the items, newest first.
 func (h *History) GetItems() []*ClipboardItem {
-	return h.Items
+	return append([]*ClipboardItem(nil), h.Items...)
 }
 
-func (h *History) Clear() {
-	h.Items = make([]*ClipboardItem, 0)
+// Clear removes every unpinned item, or everything when includePinned is
+// set, and returns the removed items.
+func (h *History) Clear(includePinned bool) []*ClipboardItem {
+	var removed []*ClipboardItem
+	kept := make([]*ClipboardItem, 0)
+	for _, item := range h.Items {
+		if item.Pinned && !includePinned {
+			kept = append(kept, item)
+		} else {
+			removed = append(removed, item)
+		}
+	}
+	h.Items = kept
+	return removed
 }
 
 func (h *History) GetItem(index int) *ClipboardItem {
@@ -44,6 +83,15 @@ func (h *History) GetItem(index int) *ClipboardItem {
 	return h.Items[index]
 }
 
+func (h *History) IndexOf(id string) int {
+	for i, item := range h.Items {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
 func (h *History) RemoveItem(index int) *ClipboardItem {
 	if index < 0 || index >= len(h.Items) {
 		return nil
@@ -59,60 +107,138 @@ func (h *History) UpdateItem(index int, newContent string) bool {
 		return false
 	}
 	
-	item := h.Items[index]
-	if item.Type == ClipText {
+	if h.Items[index].Type == ClipText {
+		item := *h.Items[index]
 		item.Content = newContent
+		h.Items[index] = &item
 		return true
 	}
 	
 	return false
 }
 
-func (h *History) ToFileFormat() []string {
-	lines := make([]string, len(h.Items))
-	for i := len(h.Items) - 1; i >= 0; i-- {
-		item := h.Items[i]
-		timestamp := item.Timestamp.In(h.Location).Format("2006-01-02 15:04:05")
-		
-		if item.Type == ClipImage {
-			lines[len(h.Items)-1-i] = fmt.Sprintf("%s\t%s\t%s", timestamp, item.FilePath, item.Type.String())
-		} else {
-			lines[len(h.Items)-1-i] = fmt.Sprintf("%s\t%s", timestamp, item.Content)
+// RemoveWhere removes the unpinned items for which expired returns true.
+func (h *History) RemoveWhere(expired func(*ClipboardItem) bool) []*ClipboardItem {
+	var removed []*ClipboardItem
+	kept := h.Items[:0]
+	for _, item := range h.Items {
+		if !item.Pinned && expired(item) {
+			removed = append(removed, item)
+			continue
 		}
+		kept = append(kept, item)
 	}
-	return lines
+	h.Items = kept
+	return removed
 }
 
-func (h *History) FromFileFormat(lines []string) {
-	h.Items = make([]*ClipboardItem, 0)
-	for i := len(lines) - 1; i >= 0; i-- {
-		line := strings.TrimSpace(lines[i])
-		if line == "" {
-			continue
+func (h *History) SetPinned(index int, pinned bool) bool {
+	if index < 0 || index >= len(h.Items) {
+		return false
+	}
+	item := *h.Items[index]
+	item.Pinned = pinned
+	h.Items[index] = &item
+	return true
+}
+
+func (h *History) SetLabels(index int, collection string, tags []string) bool {
+	if index < 0 || index >= len(h.Items) {
+		return false
+	}
+	item := *h.Items[index]
+	item.Collection = NormalizeLabel(collection)
+	item.Tags = NormalizeTags(tags)
+	h.Items[index] = &item
+	return true
+}
+
+// Merge inserts items keeping the history ordered newest first and returns
+// the items pushed out by MaxItems.
+func (h *History) Merge(items []*ClipboardItem) []*ClipboardItem {
+	h.Items = append(h.Items, items...)
+	sort.SliceStable(h.Items, func(i, j int) bool {
+		return h.Items[i].Timestamp.After(h.Items[j].Timestamp)
+	})
+	return h.Trim()
+}
+
+// Collections returns the distinct collection names in use, sorted.
+func (h *History) Collections() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, item := range h.Items {
+		if item.Collection != "" && !seen[item.Collection] {
+			seen[item.Collection] = true
+			names = append(names, item.Collection)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ToRecords returns the items oldest first, the order they are written in.
+func (h *History) ToRecords() []HistoryRecord {
+	records := make([]HistoryRecord, len(h.Items))
+	for i := len(h.Items) - 1; i >= 0; i-- {
+		records[len(h.Items)-1-i] = h.Items[i].ToRecord()
+	}
+	return records
+}
+
+func (h *History) FromRecords(records []HistoryRecord) error {
+	items := make([]*ClipboardItem, 0, len(records))
+	for i := len(records) - 1; i >= 0; i-- {
+		item, err := ItemFromRecord(records[i])
+		if err != nil {
+			return err
 		}
-		
+		items = append(items, item)
+	}
+	h.Items = items
+	return nil
+}
+
+// FromLegacyFormat parses the old tab-separated history.txt. Lines that do not
+// start with a timestamp are continuation lines of a multi-line text clip.
+func (h *History) FromLegacyFormat(lines []string) {
+	var items []*ClipboardItem
+	for _, line := range lines {
 		parts := strings.SplitN(line, "\t", 3)
-		if len(parts) < 2 {
-			continue
+		var timestamp time.Time
+		var err error
+		if len(parts) >= 2 {
+			timestamp, err = time.ParseInLocation("2006-01-02 15:04:05", parts[0], LegacyLocation())
 		}
-		
-		timestamp, err := time.ParseInLocation("2006-01-02 15:04:05", parts[0], h.Location)
-		if err != nil {
+		if len(parts) < 2 || err != nil {
+			if n := len(items); n > 0 && items[n-1].Type == ClipText {
+				items[n-1].Content += "\n" + line
+			}
 			continue
 		}
-		
+
 		item := &ClipboardItem{
-			Timestamp: timestamp,
+			ID:        NewItemID(),
+			Timestamp: timestamp.UTC(),
 		}
-		
-		if len(parts) == 3 && parts[2] == "IMAGE" {
+
+		if len(parts) == 3 && parts[2] == "IMAGE" && filepath.IsAbs(parts[1]) {
 			item.Type = ClipImage
 			item.FilePath = parts[1]
 		} else {
 			item.Type = ClipText
-			item.Content = parts[1]
+			item.Content = strings.Join(parts[1:], "\t")
+		}
+
+		items = append(items, item)
+	}
+
+	h.Items = make([]*ClipboardItem, 0, len(items))
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		if item.Type == ClipText {
+			item.Content = strings.TrimRight(item.Content, "\n")
 		}
-		
 		h.Items = append(h.Items, item)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+const (
+	ImageFormatPNG  = "png"
+	ImageFormatJPEG = "jpeg"
+
+	DefaultImageJPEGQuality = 85
+
+	NearDuplicatesOff      = "off"      // keep every capture as it is
+	NearDuplicatesCollapse = "collapse" // a near-duplicate replaces the earlier capture
+	NearDuplicatesGroup    = "group"    // keep both, grouped in the list
+
+	DefaultNearDuplicateDistance = 6
+	DefaultNearDuplicateWindow   = 60
+)
+
+// ImageConfig decides how captured images are stored. Any decodable input
+// (PNG, JPEG, GIF, BMP, TIFF or WebP) is re-encoded to Format; WebP is read
+// only, as there is no pure-Go encoder. MaxDimension and MaxBytes of 0 mean
+// no limit.
+type ImageConfig struct {
+	Format       string `json:"format"`        // png or jpeg
+	JPEGQuality  int    `json:"jpeg_quality"`  // 1-100
+	MaxDimension int    `json:"max_dimension"` // longest side in pixels, larger images are scaled down
+	MaxBytes     int    `json:"max_bytes"`     // images still larger are scaled down further, then stored as lower quality jpeg
+
+	// Two images are near-duplicates when their perceptual hashes differ in
+	// at most NearDuplicateDistance of 64 bits and they were captured at most
+	// NearDuplicateWindow seconds apart, or at any time when it is 0.
+	NearDuplicates        string `json:"near_duplicates"` // off, collapse or group
+	NearDuplicateDistance int    `json:"near_duplicate_distance"`
+	NearDuplicateWindow   int    `json:"near_duplicate_window"`
+}
+
+func NewImageConfig() ImageConfig {
+	return ImageConfig{
+		Format:                ImageFormatPNG,
+		JPEGQuality:           DefaultImageJPEGQuality,
+		NearDuplicates:        NearDuplicatesOff,
+		NearDuplicateDistance: DefaultNearDuplicateDistance,
+		NearDuplicateWindow:   DefaultNearDuplicateWindow,
+	}
+}
+
+// NearDuplicateWindowDuration is NearDuplicateWindow as a duration, 0 for
+// no limit.
+func (c ImageConfig) NearDuplicateWindowDuration() time.Duration {
+	return time.Duration(c.NearDuplicateWindow) * time.Second
+}
+
+// ImageInfo describes an image as it was captured, before normalization.
+type ImageInfo struct {
+	Format string `json:"format"` // as sniffed from the data, e.g. tiff
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
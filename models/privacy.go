@@ -0,0 +1,88 @@
+package models
+
+type PrivacyAction string
+
+const (
+	PrivacyAllow  PrivacyAction = "allow"
+	PrivacyMask   PrivacyAction = "mask"
+	PrivacyExpire PrivacyAction = "expire"
+	PrivacySkip   PrivacyAction = "skip"
+)
+
+const (
+	DefaultPrivacyExpireSeconds = 60
+	DefaultEntropyThreshold     = 3.5 // bits per character
+	DefaultEntropyMinLength     = 16
+)
+
+// Severity orders actions from most to least permissive, so the strictest
+// action wins when several rules match.
+func (a PrivacyAction) Severity() int {
+	switch a {
+	case PrivacyMask:
+		return 1
+	case PrivacyExpire:
+		return 2
+	case PrivacySkip:
+		return 3
+	default:
+		return 0
+	}
+}
+
+func (a PrivacyAction) Valid() bool {
+	switch a {
+	case PrivacyAllow, PrivacyMask, PrivacyExpire, PrivacySkip:
+		return true
+	}
+	return false
+}
+
+// PrivacyRule is a user-defined secret pattern. An empty Action falls back
+// to PrivacyConfig.DefaultAction.
+type PrivacyRule struct {
+	Name    string        `json:"name"`
+	Pattern string        `json:"pattern"`
+	Action  PrivacyAction `json:"action,omitempty"`
+}
+
+type PrivacyConfig struct {
+	Enabled            bool          `json:"enabled"`
+	DefaultAction      PrivacyAction `json:"default_action"`
+	ExpireAfterSeconds int           `json:"expire_after_seconds"`
+	DetectKnownKeys    bool          `json:"detect_known_keys"` // AWS, GitHub, Slack, JWT and private key blocks
+	DetectCards        bool          `json:"detect_cards"`      // credit card numbers passing the Luhn check
+	DetectEntropy      bool          `json:"detect_entropy"`    // long random-looking tokens such as passwords, opt-in
+	EntropyThreshold   float64       `json:"entropy_threshold"`
+	EntropyMinLength   int           `json:"entropy_min_length"`
+	Rules              []PrivacyRule `json:"rules"`
+	// MarkerPolicies maps clipboard formats that password managers offer to
+	// flag concealed or transient content to the action to take.
+	MarkerPolicies map[string]PrivacyAction `json:"marker_policies"`
+}
+
+// DefaultMarkerPolicies covers the nspasteboard.org conventions on macOS and
+// the KDE password manager hint used on Linux.
+func DefaultMarkerPolicies() map[string]PrivacyAction {
+	return map[string]PrivacyAction{
+		"org.nspasteboard.ConcealedType":     PrivacySkip,
+		"org.nspasteboard.TransientType":     PrivacySkip,
+		"org.nspasteboard.AutoGeneratedType": PrivacySkip,
+		"com.agilebits.onepassword":          PrivacySkip,
+		"x-kde-passwordManagerHint":          PrivacySkip,
+	}
+}
+
+func NewPrivacyConfig() PrivacyConfig {
+	return PrivacyConfig{
+		Enabled:            true,
+		DefaultAction:      PrivacySkip,
+		ExpireAfterSeconds: DefaultPrivacyExpireSeconds,
+		DetectKnownKeys:    true,
+		DetectCards:        true,
+		DetectEntropy:      false,
+		EntropyThreshold:   DefaultEntropyThreshold,
+		EntropyMinLength:   DefaultEntropyMinLength,
+		MarkerPolicies:     DefaultMarkerPolicies(),
+	}
+}
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	HistorySchema = "clipmini.history"
+	// HistorySchemaVersion 2 stores every timestamp in UTC.
+	HistorySchemaVersion = 2
+	// LegacyTimezone is the zone the app used to write zone-less timestamps
+	// in, whatever the zone of the machine.
+	LegacyTimezone = "Asia/Taipei"
+)
+
+var storedTimeLayouts = []string{"2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999"}
+
+func LegacyLocation() *time.Location {
+	if loc, err := time.LoadLocation(LegacyTimezone); err == nil {
+		return loc
+	}
+	return time.FixedZone("CST", 8*60*60)
+}
+
+// ParseStoredTime parses an RFC 3339 timestamp, or a zone-less one written
+// by an older version in LegacyTimezone. The zone of the input is kept, so
+// callers can tell records that still need migrating to UTC.
+func ParseStoredTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range storedTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, LegacyLocation()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad timestamp %q", s)
+}
+
+// HistoryHeader is the first line of a history file. In an encrypted file
+// every following line is a SealedRecord.
+type HistoryHeader struct {
+	Schema    string `json:"schema"`
+	Version   int    `json:"version"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+}
+
+// SealedRecord is an encrypted HistoryRecord.
+type SealedRecord struct {
+	Sealed []byte `json:"sealed"`
+}
+
+// HistoryRecord is the persisted form of a ClipboardItem, one per line.
+type HistoryRecord struct {
+	ID         string     `json:"id"`
+	Timestamp  time.Time  `json:"timestamp"`
+	Type       string     `json:"type"`
+	Content    string     `json:"content,omitempty"`
+	FilePath   string     `json:"file_path,omitempty"`
+	Pinned     bool       `json:"pinned,omitempty"`
+	Collection string     `json:"collection,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	Original   *ImageInfo `json:"original,omitempty"`
+	PHash      string     `json:"phash,omitempty"`
+}
+
+// UnmarshalJSON accepts zone-less timestamps from older history files.
+func (r *HistoryRecord) UnmarshalJSON(data []byte) error {
+	type plain HistoryRecord
+	aux := struct {
+		*plain
+		Timestamp string  `json:"timestamp"`
+		ExpiresAt *string `json:"expires_at,omitempty"`
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	var err error
+	if r.Timestamp, err = ParseStoredTime(aux.Timestamp); err != nil {
+		return err
+	}
+	r.ExpiresAt = nil
+	if aux.ExpiresAt != nil {
+		t, err := ParseStoredTime(*aux.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		r.ExpiresAt = &t
+	}
+	return nil
+}
+
+// IsUTC reports whether the record is already stored the v2 way.
+func (r HistoryRecord) IsUTC() bool {
+	return r.Timestamp.Location() == time.UTC && (r.ExpiresAt == nil || r.ExpiresAt.Location() == time.UTC)
+}
+
+func NewItemID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+func ParseClipType(s string) (ClipType, error) {
+	switch s {
+	case "TEXT":
+		return ClipText, nil
+	case "IMAGE":
+		return ClipImage, nil
+	default:
+		return ClipText, fmt.Errorf("unknown clip type %q", s)
+	}
+}
+
+func (item *ClipboardItem) ToRecord() HistoryRecord {
+	var expiresAt *time.Time
+	if !item.ExpiresAt.IsZero() {
+		t := item.ExpiresAt.UTC()
+		expiresAt = &t
+	}
+	return HistoryRecord{
+		ID:         item.ID,
+		Timestamp:  item.Timestamp.UTC(),
+		Type:       item.Type.String(),
+		Content:    item.Content,
+		FilePath:   item.FilePath,
+		Pinned:     item.Pinned,
+		Collection: item.Collection,
+		Tags:       item.Tags,
+		ExpiresAt:  expiresAt,
+		Original:   item.Original,
+		PHash:      item.PHash,
+	}
+}
+
+func ItemFromRecord(rec HistoryRecord) (*ClipboardItem, error) {
+	typ, err := ParseClipType(rec.Type)
+	if err != nil {
+		return nil, err
+	}
+	id := rec.ID
+	if id == "" {
+		id = NewItemID()
+	}
+	var expiresAt time.Time
+	if rec.ExpiresAt != nil {
+		expiresAt = rec.ExpiresAt.UTC()
+	}
+	return &ClipboardItem{
+		ID:         id,
+		Timestamp:  rec.Timestamp.UTC(),
+		Content:    rec.Content,
+		Type:       typ,
+		FilePath:   rec.FilePath,
+		Pinned:     rec.Pinned,
+		Collection: NormalizeLabel(rec.Collection),
+		Tags:       NormalizeTags(rec.Tags),
+		ExpiresAt:  expiresAt,
+		Original:   rec.Original,
+		PHash:      rec.PHash,
+	}, nil
+}
@@ -0,0 +1,11 @@
+package models
+
+// RetentionRule deletes unpinned items matching Query once they are older
+// than MaxAgeSeconds. Query uses the history query language, so
+// "type:image" or "tag:otp" select what the rule applies to and an empty
+// query applies to every item.
+type RetentionRule struct {
+	Name          string `json:"name"`
+	Query         string `json:"query"`
+	MaxAgeSeconds int    `json:"max_age_seconds"`
+}
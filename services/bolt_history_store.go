@@ -0,0 +1,272 @@
+package services
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"clipmini/models"
+)
+
+var (
+	boltItemsBucket = []byte("items")
+	boltTimeBucket  = []byte("by_time")
+	boltMetaBucket  = []byte("meta")
+	boltVersionKey  = []byte("version")
+)
+
+// BoltHistoryStore keeps history in an embedded bbolt database. Items are
+// stored by ID and indexed by timestamp, so inserts and deletes touch only
+// the affected keys regardless of history size. Values are sealed with the
+// vault once encryption is enabled.
+type BoltHistoryStore struct {
+	db    *bolt.DB
+	vault *Vault
+}
+
+func OpenBoltHistoryStore(path string, vault *Vault) (*BoltHistoryStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := openBoltHistoryDB(path)
+	if err != nil {
+		return nil, err
+	}
+	return &BoltHistoryStore{db: db, vault: vault}, nil
+}
+
+func openBoltHistoryDB(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+		if v := meta.Get(boltVersionKey); v != nil {
+			if version, _ := strconv.Atoi(string(v)); version > models.HistorySchemaVersion {
+				return fmt.Errorf("unsupported history schema v%d", version)
+			}
+		}
+		if err := meta.Put(boltVersionKey, []byte(strconv.Itoa(models.HistorySchemaVersion))); err != nil {
+			return err
+		}
+		return createBoltHistoryBuckets(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func createBoltHistoryBuckets(tx *bolt.Tx) error {
+	if _, err := tx.CreateBucketIfNotExists(boltItemsBucket); err != nil {
+		return err
+	}
+	_, err := tx.CreateBucketIfNotExists(boltTimeBucket)
+	return err
+}
+
+// boltTimeKey sorts by timestamp first and breaks ties by ID.
+func boltTimeKey(t time.Time, id string) []byte {
+	key := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return append(key, id...)
+}
+
+func (bs *BoltHistoryStore) Load() ([]*models.ClipboardItem, error) {
+	items, migrate, err := bs.items()
+	if err != nil {
+		return nil, err
+	}
+	// Items written before schema v2 carry local time stamps.
+	if len(migrate) > 0 {
+		if err := bs.Put(migrate...); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// loadBoltHistoryReadOnly reads the database at path without creating,
+// migrating or locking it for writing.
+func loadBoltHistoryReadOnly(path string, vault *Vault) ([]*models.ClipboardItem, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer db.Close()
+	items, _, err := (&BoltHistoryStore{db: db, vault: vault}).items()
+	return items, err
+}
+
+// items reads every item, newest first, and returns those still carrying
+// local time stamps as migrate.
+func (bs *BoltHistoryStore) items() (items, migrate []*models.ClipboardItem, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		byID, byTime := tx.Bucket(boltItemsBucket), tx.Bucket(boltTimeBucket)
+		if byID == nil || byTime == nil {
+			return nil
+		}
+		c := byTime.Cursor()
+		for k, id := c.Last(); k != nil; k, id = c.Prev() {
+			raw := byID.Get(id)
+			if raw == nil {
+				continue
+			}
+			rec, err := bs.decode(raw)
+			if err != nil {
+				return fmt.Errorf("item %s: %w", id, err)
+			}
+			item, err := models.ItemFromRecord(rec)
+			if err != nil {
+				return err
+			}
+			items = append(items, item)
+			if !rec.IsUTC() {
+				migrate = append(migrate, item)
+			}
+		}
+		return nil
+	})
+	return items, migrate, err
+}
+
+func (bs *BoltHistoryStore) decode(raw []byte) (models.HistoryRecord, error) {
+	var rec models.HistoryRecord
+	plain, err := bs.vault.Open(raw)
+	if err != nil {
+		return rec, err
+	}
+	err = json.Unmarshal(plain, &rec)
+	return rec, err
+}
+
+func (bs *BoltHistoryStore) encode(item *models.ClipboardItem) ([]byte, error) {
+	raw, err := json.Marshal(item.ToRecord())
+	if err != nil {
+		return nil, err
+	}
+	return bs.vault.Seal(raw)
+}
+
+func (bs *BoltHistoryStore) Put(items ...*models.ClipboardItem) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		byID := tx.Bucket(boltItemsBucket)
+		byTime := tx.Bucket(boltTimeBucket)
+		for _, item := range items {
+			if err := bs.deleteTimeKey(byID, byTime, item.ID); err != nil {
+				return err
+			}
+			raw, err := bs.encode(item)
+			if err != nil {
+				return err
+			}
+			if err := byID.Put([]byte(item.ID), raw); err != nil {
+				return err
+			}
+			if err := byTime.Put(boltTimeKey(item.Timestamp, item.ID), []byte(item.ID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (bs *BoltHistoryStore) Delete(ids ...string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		byID := tx.Bucket(boltItemsBucket)
+		byTime := tx.Bucket(boltTimeBucket)
+		for _, id := range ids {
+			if err := bs.deleteTimeKey(byID, byTime, id); err != nil {
+				return err
+			}
+			if err := byID.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deleteTimeKey drops the index entry of the stored version of an item.
+func (bs *BoltHistoryStore) deleteTimeKey(byID, byTime *bolt.Bucket, id string) error {
+	raw := byID.Get([]byte(id))
+	if raw == nil {
+		return nil
+	}
+	rec, err := bs.decode(raw)
+	if err != nil {
+		return err
+	}
+	return byTime.Delete(boltTimeKey(rec.Timestamp, id))
+}
+
+// StageRewrite writes items into a fresh database file, so no free page of
+// the old file keeps data sealed with an old key, or none.
+func (bs *BoltHistoryStore) StageRewrite(vault *Vault, items ...*models.ClipboardItem) error {
+	tmpPath := bs.db.Path() + rekeySuffix
+	_ = os.Remove(tmpPath)
+
+	db, err := openBoltHistoryDB(tmpPath)
+	if err != nil {
+		return err
+	}
+	fresh := &BoltHistoryStore{db: db, vault: vault}
+	if err := fresh.Put(items...); err != nil {
+		fresh.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	return fresh.Close()
+}
+
+// CommitRewrite swaps the staged file in. When that fails the old file is
+// opened again and the rename error returned.
+func (bs *BoltHistoryStore) CommitRewrite() error {
+	path := bs.db.Path()
+	if err := bs.db.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+rekeySuffix, path); err != nil {
+		if db, openErr := openBoltHistoryDB(path); openErr == nil {
+			bs.db = db
+		}
+		return err
+	}
+	db, err := openBoltHistoryDB(path)
+	if err != nil {
+		return err
+	}
+	bs.db = db
+	return nil
+}
+
+func (bs *BoltHistoryStore) Clear() error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltItemsBucket, boltTimeBucket} {
+			if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return createBoltHistoryBuckets(tx)
+	})
+}
+
+func (bs *BoltHistoryStore) Close() error {
+	return bs.db.Close()
+}
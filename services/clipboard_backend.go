@@ -0,0 +1,152 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+
+	"clipmini/models"
+)
+
+// ClipboardBackend abstracts the system clipboard so controllers never talk
+// to platform tools directly.
+type ClipboardBackend interface {
+	Name() string
+	ReadText() (string, error)
+	WriteText(text string) error
+	ReadImage() ([]byte, error)
+	WriteImage(data []byte) error
+	Formats() ([]string, error)
+	Clear() error
+}
+
+// ChangeTokenBackend is implemented by backends that can tell cheaply
+// whether the clipboard changed, without reading its content.
+type ChangeTokenBackend interface {
+	// ChangeToken returns a value that differs whenever the content changed,
+	// or ErrUnsupported when the current owner offers none.
+	ChangeToken() (string, error)
+}
+
+var ErrUnsupported = errors.New("operation not supported by clipboard backend")
+
+// NewClipboardBackend returns the backend named in the config, or the one
+// matching the running platform when it is set to "auto".
+func NewClipboardBackend(config *models.AppConfig) (ClipboardBackend, error) {
+	switch config.ClipboardBackend {
+	case "", "auto":
+	case "macos":
+		return NewMacOSClipboardBackend(), nil
+	case "linux":
+		return NewLinuxClipboardBackend()
+	case "memory":
+		backend := NewMemoryClipboardBackend()
+		if config.MemoryScriptPath != "" {
+			steps, err := LoadClipboardScript(config.MemoryScriptPath)
+			if err != nil {
+				return nil, err
+			}
+			go func() {
+				for err := range backend.Play(steps, nil) {
+					log.Printf("Failed to play clipboard script: %v", err)
+				}
+			}()
+		}
+		return backend, nil
+	default:
+		return nil, fmt.Errorf("unknown clipboard backend %q", config.ClipboardBackend)
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return NewMacOSClipboardBackend(), nil
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return NewLinuxClipboardBackend()
+	default:
+		return nil, fmt.Errorf("no clipboard backend for %s", runtime.GOOS)
+	}
+}
+
+// HasImageFormat reports whether any of the offered formats is an image,
+// accepting both MIME types and macOS UTIs.
+func HasImageFormat(formats []string) bool {
+	for _, f := range formats {
+		if isImageFormat(f) {
+			return true
+		}
+	}
+	return false
+}
+
+func isImageFormat(f string) bool {
+	switch f {
+	case "public.png", "public.tiff":
+		return true
+	}
+	return strings.HasPrefix(f, "image/")
+}
+
+// GetImageHash is a quick fingerprint for noticing that the clipboard image
+// changed.
+func GetImageHash(data []byte) string {
+	h := sha1.Sum(data)
+	return hex.EncodeToString(h[:])
+}
+
+// ContentHash names stored image blobs.
+func ContentHash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func runClipboardCommand(cmd *exec.Cmd, stdin io.Reader) ([]byte, error) {
+	var out, stderr bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %w: %s", cmd.Args[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+	return out.Bytes(), nil
+}
+
+// writeClipboardCommand feeds stdin to tools such as xclip and wl-copy that
+// fork to keep serving the selection. Their output must not be captured,
+// otherwise Wait blocks until the forked child exits.
+func writeClipboardCommand(cmd *exec.Cmd, stdin io.Reader) error {
+	cmd.Stdin = stdin
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+	return nil
+}
+
+func normalizeClipboardText(s string) string {
+	s = strings.TrimRight(s, "\r\n")
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "�")
+	}
+	return s
+}
+
+func splitFormatLines(out []byte) []string {
+	var formats []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			formats = append(formats, line)
+		}
+	}
+	return formats
+}
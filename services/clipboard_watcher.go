@@ -0,0 +1,315 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// pollBackoffMax caps how far polling slows down while the clipboard is idle.
+const pollBackoffMax = 5 * time.Second
+
+// ClipboardWatcher signals clipboard changes on Changes. Signals are
+// coalesced and may be spurious, so receivers read the clipboard and
+// compare. Changes is closed when the watcher stops.
+type ClipboardWatcher interface {
+	Changes() <-chan struct{}
+	Close() error
+}
+
+// WatchableBackend is implemented by backends that can watch the clipboard
+// without polling it.
+type WatchableBackend interface {
+	Watch() (ClipboardWatcher, error)
+}
+
+// NewClipboardWatcher watches natively when the backend can and falls back
+// to adaptive polling otherwise, or when the native watcher dies.
+func NewClipboardWatcher(backend ClipboardBackend, interval time.Duration) *FallbackWatcher {
+	fw := &FallbackWatcher{
+		changes:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		interval: interval,
+	}
+	var native ClipboardWatcher
+	if wb, ok := backend.(WatchableBackend); ok {
+		var err error
+		if native, err = wb.Watch(); err != nil {
+			log.Printf("Native clipboard watching unavailable, polling instead: %v", err)
+			native = nil
+		}
+	}
+	go fw.run(native)
+	return fw
+}
+
+// FallbackWatcher forwards the events of a native watcher and switches to
+// a PollingWatcher when there is none.
+type FallbackWatcher struct {
+	changes  chan struct{}
+	stop     chan struct{}
+	once     sync.Once
+	mu       sync.Mutex
+	interval time.Duration
+	poller   *PollingWatcher
+	native   bool
+}
+
+func (fw *FallbackWatcher) Changes() <-chan struct{} {
+	return fw.changes
+}
+
+// Native reports whether changes currently come from a native watcher.
+func (fw *FallbackWatcher) Native() bool {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.native
+}
+
+func (fw *FallbackWatcher) Close() error {
+	fw.once.Do(func() { close(fw.stop) })
+	return nil
+}
+
+// SetInterval changes the base polling interval.
+func (fw *FallbackWatcher) SetInterval(d time.Duration) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.interval = d
+	if fw.poller != nil {
+		fw.poller.SetInterval(d)
+	}
+}
+
+// Report tells the poller whether the last signal found new content, so it
+// can back off while the clipboard is idle.
+func (fw *FallbackWatcher) Report(changed bool) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.poller != nil {
+		fw.poller.Report(changed)
+	}
+}
+
+func (fw *FallbackWatcher) run(native ClipboardWatcher) {
+	defer close(fw.changes)
+	if native != nil {
+		fw.mu.Lock()
+		fw.native = true
+		fw.mu.Unlock()
+		if !fw.forward(native) {
+			return
+		}
+		log.Printf("Native clipboard watcher stopped, polling instead")
+	}
+
+	fw.mu.Lock()
+	fw.native = false
+	fw.poller = NewPollingWatcher(fw.interval)
+	fw.mu.Unlock()
+	fw.forward(fw.poller)
+}
+
+// forward relays w until it stops, which reports true, or the fallback
+// watcher is closed.
+func (fw *FallbackWatcher) forward(w ClipboardWatcher) bool {
+	defer w.Close()
+	for {
+		select {
+		case _, ok := <-w.Changes():
+			if !ok {
+				return true
+			}
+			notify(fw.changes)
+		case <-fw.stop:
+			return false
+		}
+	}
+}
+
+// notify sends a signal unless one is already pending.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// PollingWatcher signals on a ticker. Each signal that finds nothing new
+// stretches the interval by half, up to pollBackoffMax; new content resets
+// it.
+type PollingWatcher struct {
+	changes chan struct{}
+	stop    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	base    time.Duration
+	current time.Duration
+}
+
+func NewPollingWatcher(interval time.Duration) *PollingWatcher {
+	pw := &PollingWatcher{
+		changes: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		base:    interval,
+		current: interval,
+	}
+	go pw.run()
+	return pw
+}
+
+func (pw *PollingWatcher) Changes() <-chan struct{} {
+	return pw.changes
+}
+
+func (pw *PollingWatcher) Close() error {
+	pw.once.Do(func() { close(pw.stop) })
+	return nil
+}
+
+func (pw *PollingWatcher) SetInterval(d time.Duration) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pw.base, pw.current = d, d
+}
+
+func (pw *PollingWatcher) Report(changed bool) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if changed {
+		pw.current = pw.base
+		return
+	}
+	pw.current += pw.current / 2
+	if limit := max(pw.base, pollBackoffMax); pw.current > limit {
+		pw.current = limit
+	}
+}
+
+func (pw *PollingWatcher) next() time.Duration {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.current
+}
+
+func (pw *PollingWatcher) run() {
+	defer close(pw.changes)
+	timer := time.NewTimer(pw.next())
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			notify(pw.changes)
+			timer.Reset(pw.next())
+		case <-pw.stop:
+			return
+		}
+	}
+}
+
+// processWatcher turns the output of a clipboard tool into change signals.
+type processWatcher struct {
+	changes chan struct{}
+	stop    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+}
+
+func newProcessWatcher() *processWatcher {
+	return &processWatcher{changes: make(chan struct{}, 1), stop: make(chan struct{})}
+}
+
+func (pw *processWatcher) Changes() <-chan struct{} {
+	return pw.changes
+}
+
+func (pw *processWatcher) Close() error {
+	pw.once.Do(func() {
+		close(pw.stop)
+		pw.mu.Lock()
+		defer pw.mu.Unlock()
+		if pw.cmd != nil && pw.cmd.Process != nil {
+			pw.cmd.Process.Kill()
+		}
+	})
+	return nil
+}
+
+func (pw *processWatcher) stopped() bool {
+	select {
+	case <-pw.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// start runs cmd unless the watcher was closed in the meantime.
+func (pw *processWatcher) start(cmd *exec.Cmd) error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.stopped() {
+		return errors.New("watcher closed")
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pw.cmd = cmd
+	return nil
+}
+
+// watchLines runs a long-lived command that prints a line per change, such
+// as wl-paste --watch.
+func watchLines(cmd *exec.Cmd) (*processWatcher, error) {
+	pw := newProcessWatcher()
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := pw.start(cmd); err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(pw.changes)
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			notify(pw.changes)
+		}
+		if err := cmd.Wait(); err != nil && !pw.stopped() {
+			log.Printf("%s: %v", cmd.Args[0], err)
+		}
+	}()
+	return pw, nil
+}
+
+// watchExits reruns a command that exits once per change, such as
+// clipnotify.
+func watchExits(newCmd func() *exec.Cmd) (*processWatcher, error) {
+	pw := newProcessWatcher()
+	first := newCmd()
+	if err := pw.start(first); err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(pw.changes)
+		cmd := first
+		for {
+			if err := cmd.Wait(); err != nil {
+				if !pw.stopped() {
+					log.Printf("%s: %v", cmd.Args[0], err)
+				}
+				return
+			}
+			notify(pw.changes)
+			cmd = newCmd()
+			if err := pw.start(cmd); err != nil {
+				return
+			}
+		}
+	}()
+	return pw, nil
+}
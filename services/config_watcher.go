@@ -0,0 +1,129 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"clipmini/models"
+)
+
+// configReloadDelay coalesces the burst of events an editor or an atomic
+// rename produces into one reload.
+const configReloadDelay = 200 * time.Millisecond
+
+// ConfigWatcher reloads the settings file when it changes on disk and hands
+// every valid new version to its subscribers. An invalid file is logged and
+// ignored, the running configuration stays in effect.
+type ConfigWatcher struct {
+	path        string
+	dirs        models.AppDirs
+	mu          sync.Mutex
+	current     *models.AppConfig
+	subscribers []func(old, updated *models.AppConfig)
+	watcher     *fsnotify.Watcher
+	done        chan struct{}
+}
+
+func NewConfigWatcher(config *models.AppConfig) *ConfigWatcher {
+	return &ConfigWatcher{
+		path:    filepath.Clean(config.ConfigPath),
+		dirs:    config.Dirs,
+		current: config.Clone(),
+		done:    make(chan struct{}),
+	}
+}
+
+// Subscribe registers fn to be called with the previous and the new
+// configuration after each reload. Callbacks run on the watcher goroutine in
+// the order they subscribed.
+func (cw *ConfigWatcher) Subscribe(fn func(old, updated *models.AppConfig)) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.subscribers = append(cw.subscribers, fn)
+}
+
+// Start watches the directory of the settings file, since saving it
+// atomically replaces the file itself.
+func (cw *ConfigWatcher) Start() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(cw.path)); err != nil {
+		watcher.Close()
+		return err
+	}
+	cw.watcher = watcher
+	go cw.run()
+	return nil
+}
+
+func (cw *ConfigWatcher) Close() error {
+	if cw.watcher == nil {
+		return nil
+	}
+	close(cw.done)
+	return cw.watcher.Close()
+}
+
+func (cw *ConfigWatcher) run() {
+	timer := time.NewTimer(configReloadDelay)
+	timer.Stop()
+	for {
+		select {
+		case event, ok := <-cw.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) == cw.path {
+				timer.Reset(configReloadDelay)
+			}
+		case err, ok := <-cw.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Config watcher: %v", err)
+		case <-timer.C:
+			if err := cw.Reload(); err != nil {
+				log.Printf("Config reload failed, keeping current settings: %v", err)
+			}
+		case <-cw.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Reload reads the settings file and notifies the subscribers if it changed.
+// A deleted file is ignored rather than reset to the defaults.
+func (cw *ConfigWatcher) Reload() error {
+	if _, err := os.Stat(cw.path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	updated, err := models.LoadAppConfig(cw.dirs)
+	if err != nil {
+		return err
+	}
+
+	cw.mu.Lock()
+	old := cw.current
+	if reflect.DeepEqual(old, updated) {
+		cw.mu.Unlock()
+		return nil
+	}
+	cw.current = updated
+	subscribers := append([]func(old, updated *models.AppConfig){}, cw.subscribers...)
+	cw.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(old, updated.Clone())
+	}
+	return nil
+}
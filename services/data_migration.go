@@ -0,0 +1,146 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"clipmini/models"
+	"clipmini/utils"
+)
+
+// dataDirMarker in the config directory remembers the data directory of the
+// last run, so a changed location can be noticed and its data moved.
+const dataDirMarker = "data_dir"
+
+// MigrateDataDir moves the files of the previous data directory into the
+// current one when the resolved location changed, e.g. from the directory
+// every version used to hard-code to the XDG one. Data already in the new
+// directory is never overwritten; the old directory is then left alone.
+func MigrateDataDir(config *models.AppConfig) error {
+	markerPath := filepath.Join(config.Dirs.Config, dataDirMarker)
+	previous := models.LegacyDataDir()
+	if data, err := os.ReadFile(markerPath); err == nil {
+		previous = strings.TrimSpace(string(data))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	current := config.LogDirPath
+
+	if previous != "" && filepath.Clean(previous) != filepath.Clean(current) && dirExists(previous) {
+		if dirEmpty(current, config.ConfigPath, markerPath) {
+			log.Printf("Moving data from %s to %s", previous, current)
+			if err := moveDirContents(previous, current, config.ConfigPath, markerPath); err != nil {
+				return err
+			}
+		} else {
+			log.Printf("Not moving data from %s, %s is already in use", previous, current)
+		}
+	}
+
+	if err := os.MkdirAll(config.Dirs.Config, 0o755); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(markerPath, []byte(current+"\n"), 0o644)
+}
+
+// moveDirContents moves every entry of from into to, skipping the settings
+// files when both directories are the same config directory on macOS.
+func moveDirContents(from, to string, skip ...string) error {
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(to, 0o700); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		src := filepath.Join(from, e.Name())
+		if isSkipped(src, skip) {
+			continue
+		}
+		dst := filepath.Join(to, e.Name())
+		if err := os.Rename(src, dst); err == nil {
+			continue
+		}
+		// A different file system; copy, then remove the original.
+		if err := copyTree(src, dst); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(src); err != nil {
+			return err
+		}
+	}
+	_ = os.Remove(from) // only succeeds when nothing was left behind
+	return nil
+}
+
+func isSkipped(path string, skip []string) bool {
+	for _, s := range skip {
+		if path == s || utils.IsAtomicTemp(path, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// dirEmpty reports whether path holds nothing but the skipped files.
+func dirEmpty(path string, skip ...string) bool {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return true
+	}
+	for _, e := range entries {
+		if !isSkipped(filepath.Join(path, e.Name()), skip) {
+			return false
+		}
+	}
+	return true
+}
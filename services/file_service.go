@@ -2,85 +2,309 @@ package services
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	iofs "io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 	"unicode/utf8"
 
 	"clipmini/models"
+	"clipmini/utils"
 )
 
 type FileService struct {
 	config *models.AppConfig
+	vault  *Vault
+	// The image and thumbnail directories are fixed at startup and copied
+	// here, since thumbnail workers use them while settings are applied.
+	imageDir     string
+	thumbnailDir string
+	thumbnails   *ThumbnailCache // told when images go away, set by NewThumbnailCache
 }
 
 func NewFileService(config *models.AppConfig) *FileService {
 	return &FileService{
-		config: config,
+		config:       config,
+		vault:        NewVault(),
+		imageDir:     config.ImageDirPath,
+		thumbnailDir: filepath.Join(config.CacheDirPath, "thumbnails"),
 	}
 }
 
-func (fs *FileService) ReadHistoryLines() ([]string, error) {
+// Vault seals the history file and saved images once encryption is enabled.
+func (fs *FileService) Vault() *Vault {
+	return fs.vault
+}
+
+// ReadHistoryRecords loads the JSON Lines history file: a header line with the
+// schema version followed by one record per item, oldest first.
+func (fs *FileService) ReadHistoryRecords() ([]models.HistoryRecord, error) {
 	f, err := os.Open(fs.config.LogFilePath)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	var lines []string
-	sc := bufio.NewScanner(f)
-	for sc.Scan() {
-		line := strings.TrimSpace(sc.Text())
-		if line == "" {
-			continue
-		}
-		if !utf8.ValidString(line) {
-			line = strings.ToValidUTF8(line, "?")
-		}
-		lines = append(lines, line)
-	}
-	return lines, nil
+	return decodeHistoryRecords(f, fs.config.LogFilePath, fs.vault)
+}
+
+// WriteHistoryRecords replaces the history file atomically so a crash never
+// leaves a half-written history behind.
+func (fs *FileService) WriteHistoryRecords(records []models.HistoryRecord) error {
+	return fs.writeHistoryRecords(fs.config.LogFilePath, records, fs.vault)
 }
 
-func (fs *FileService) WriteHistoryLines(lines []string) error {
-	if err := os.MkdirAll(fs.config.LogDirPath, 0o755); err != nil {
+func (fs *FileService) writeHistoryRecords(path string, records []models.HistoryRecord, vault *Vault) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return err
 	}
-	
-	f, err := os.Create(fs.config.LogFilePath)
+
+	data, err := encodeHistoryRecords(records, vault)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	
-	for _, line := range lines {
-		if _, err := f.WriteString(line + "\n"); err != nil {
-			return err
+	return utils.WriteFileAtomic(path, data, 0o600)
+}
+
+// DecodeHistoryRecords parses the JSON Lines history format; name is only
+// used in error messages.
+func DecodeHistoryRecords(src io.Reader, name string) ([]models.HistoryRecord, error) {
+	return decodeHistoryRecords(src, name, nil)
+}
+
+func decodeHistoryRecords(src io.Reader, name string, vault *Vault) ([]models.HistoryRecord, error) {
+	r := bufio.NewReader(src)
+	var records []models.HistoryRecord
+	var header models.HistoryHeader
+	lineNo := 0
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			lineNo++
+			if lineNo == 1 {
+				if err := json.Unmarshal(line, &header); err != nil {
+					return nil, fmt.Errorf("%s: bad header: %w", name, err)
+				}
+				if header.Schema != models.HistorySchema || header.Version > models.HistorySchemaVersion {
+					return nil, fmt.Errorf("%s: unsupported history schema %s v%d", name, header.Schema, header.Version)
+				}
+				// With a key set, a plaintext history was not written by us
+				if !header.Encrypted && vault.Enabled() {
+					return nil, fmt.Errorf("%s: %w", name, ErrNotSealed)
+				}
+			} else {
+				if header.Encrypted {
+					var sealed models.SealedRecord
+					if err := json.Unmarshal(line, &sealed); err != nil {
+						return nil, fmt.Errorf("%s:%d: %w", name, lineNo, err)
+					}
+					if !IsSealed(sealed.Sealed) {
+						return nil, fmt.Errorf("%s:%d: %w", name, lineNo, ErrNotSealed)
+					}
+					plain, err := vault.Open(sealed.Sealed)
+					if err != nil {
+						return nil, fmt.Errorf("%s:%d: %w", name, lineNo, err)
+					}
+					line = plain
+				}
+				var rec models.HistoryRecord
+				if err := json.Unmarshal(line, &rec); err != nil {
+					return nil, fmt.Errorf("%s:%d: %w", name, lineNo, err)
+				}
+				records = append(records, rec)
+			}
+		}
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
-	return nil
 }
 
-func (fs *FileService) SaveImage(data []byte, timestamp string) (string, error) {
-	if err := os.MkdirAll(fs.config.ImageDirPath, 0o755); err != nil {
+func EncodeHistoryRecords(records []models.HistoryRecord) ([]byte, error) {
+	return encodeHistoryRecords(records, nil)
+}
+
+// encodeHistoryRecords seals every record when the vault has a key.
+func encodeHistoryRecords(records []models.HistoryRecord, vault *Vault) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	encrypted := vault.Enabled()
+	if err := enc.Encode(models.HistoryHeader{Schema: models.HistorySchema, Version: models.HistorySchemaVersion, Encrypted: encrypted}); err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		if !encrypted {
+			if err := enc.Encode(rec); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		raw, err := json.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := vault.Seal(raw)
+		if err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(models.SealedRecord{Sealed: sealed}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (fs *FileService) HistoryFileExists() bool {
+	_, err := os.Stat(fs.config.LogFilePath)
+	return err == nil
+}
+
+// ReadLegacyHistoryLines reads the pre-JSON history.txt without trimming, so
+// continuation lines of multi-line clips survive the migration.
+func (fs *FileService) ReadLegacyHistoryLines() ([]string, error) {
+	data, err := os.ReadFile(fs.config.LegacyLogPath)
+	if err != nil {
+		return nil, err
+	}
+	text := string(data)
+	if !utf8.ValidString(text) {
+		text = strings.ToValidUTF8(text, "?")
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+	return lines, nil
+}
+
+// RetireHistoryFile keeps the JSON Lines history around as a backup after it
+// was imported into another store.
+func (fs *FileService) RetireHistoryFile() error {
+	return fs.retire(fs.config.LogFilePath)
+}
+
+// RetireLegacyHistory keeps the migrated history.txt around as a backup.
+func (fs *FileService) RetireLegacyHistory() error {
+	return fs.retire(fs.config.LegacyLogPath)
+}
+
+// retire renames an imported file to a .migrated backup. With encryption on
+// the file is removed instead, a plaintext backup would defeat it.
+func (fs *FileService) retire(path string) error {
+	if fs.vault.Enabled() {
+		return os.Remove(path)
+	}
+	return os.Rename(path, path+".migrated")
+}
+
+// RemovePlaintextBackups deletes the .migrated backups left by earlier
+// imports once the history is encrypted.
+func (fs *FileService) RemovePlaintextBackups() {
+	for _, path := range []string{fs.config.LogFilePath, fs.config.LegacyLogPath} {
+		_ = os.Remove(path + ".migrated")
+	}
+}
+
+// SaveImage stores an encoded image as a blob named after its hash in a
+// directory sharded by the first two hex digits, so copying the same image
+// again reuses the file. ext must match the encoding. With encryption on the
+// hash is keyed; see Vault.BlobName.
+func (fs *FileService) SaveImage(data []byte, ext string) (string, error) {
+	if ext == "" || strings.ContainsAny(ext, `./\`) {
+		return "", fmt.Errorf("bad image extension %q", ext)
+	}
+	path := fs.BlobPath(fs.vault.BlobName(data), ext)
+	if fs.ImageExists(path) {
+		return path, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return "", err
 	}
-	
-	filename := fmt.Sprintf("image_%s.png",
-		strings.ReplaceAll(strings.ReplaceAll(timestamp, ":", "-"), " ", "_"))
-	path := filepath.Join(fs.config.ImageDirPath, filename)
-	
-	if err := os.WriteFile(path, data, 0o644); err != nil {
+	if err := fs.WriteImage(path, data); err != nil {
 		return "", err
 	}
 	return path, nil
 }
 
+// BlobPath is where SaveImage keeps an image with the given hash.
+func (fs *FileService) BlobPath(hash, ext string) string {
+	return filepath.Join(fs.imageDir, hash[:2], hash+"."+ext)
+}
+
+// IsBlobPath reports whether path is laid out the way SaveImage names blobs.
+func (fs *FileService) IsBlobPath(path string) bool {
+	ext := filepath.Ext(path)
+	hash := strings.TrimSuffix(filepath.Base(path), ext)
+	return len(hash) == 64 && ext != "" && fs.BlobPath(hash, ext[1:]) == path
+}
+
+// ListImageFiles returns every image file under the image directory, leaving
+// out hidden files and the copies staged by an unfinished rekey, which
+// RecoverRekey still needs.
+func (fs *FileService) ListImageFiles() ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(fs.imageDir, func(path string, d iofs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !strings.HasPrefix(d.Name(), ".") && !strings.HasSuffix(d.Name(), rekeySuffix) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// WriteImage replaces an image file, sealing it when encryption is on.
+func (fs *FileService) WriteImage(path string, data []byte) error {
+	sealed, err := fs.vault.Seal(data)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, sealed, 0o600)
+}
+
+// CleanupImageFiles deletes images together with their cached thumbnails.
 func (fs *FileService) CleanupImageFiles(imagePaths []string) {
 	for _, path := range imagePaths {
+		thumbnail := fs.ThumbnailPath(path)
 		_ = os.Remove(path)
+		_ = os.Remove(thumbnail)
+		fs.thumbnails.Forget(path)
+		if dir := filepath.Dir(path); dir != fs.imageDir {
+			_ = os.Remove(dir) // the shard, once it is empty
+		}
+		_ = os.Remove(filepath.Dir(thumbnail))
+	}
+}
+
+// ThumbnailPath is where the thumbnail of an image is cached, keyed by the
+// content hash of blobs and by the path of anything else.
+func (fs *FileService) ThumbnailPath(imagePath string) string {
+	hash := strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+	if !fs.IsBlobPath(imagePath) {
+		hash = ContentHash([]byte(imagePath))
 	}
+	return filepath.Join(fs.thumbnailDir, hash[:2], hash+".png")
+}
+
+// DeleteThumbnails drops the whole thumbnail cache; it is rebuilt on demand.
+func (fs *FileService) DeleteThumbnails() error {
+	fs.thumbnails.Reset()
+	return os.RemoveAll(fs.thumbnailDir)
 }
 
 func (fs *FileService) DeleteHistoryFile() error {
@@ -88,10 +312,19 @@ func (fs *FileService) DeleteHistoryFile() error {
 }
 
 func (fs *FileService) DeleteImageDirectory() error {
-	return os.RemoveAll(fs.config.ImageDirPath)
+	return os.RemoveAll(fs.imageDir)
+}
+
+// ReadImage returns the decrypted image data.
+func (fs *FileService) ReadImage(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.vault.Open(data)
 }
 
 func (fs *FileService) ImageExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"unicode"
+)
+
+const (
+	fuzzyScoreMatch       = 16
+	fuzzyBonusBoundary    = 8
+	fuzzyBonusConsecutive = 8
+	fuzzyBonusFirstChar   = 2 // multiplier for the boundary bonus of the first pattern char
+	fuzzyPenaltyGapStart  = 3
+	fuzzyPenaltyGapExtend = 1
+)
+
+// fuzzyMaxStarts bounds how many start positions are tried on long texts.
+const fuzzyMaxStarts = 64
+
+// FuzzyMatch matches pattern against text in the spirit of fzf's v1
+// algorithm: from a start position find the first window containing the
+// pattern runes in order, shrink it from the end, then score the chosen
+// positions. Every occurrence of the first pattern rune is tried as a start
+// and the best score wins. Matching is case-insensitive unless the pattern
+// contains an upper-case letter. Positions are rune offsets into text.
+func FuzzyMatch(text, pattern string) (int, []int, bool) {
+	pat := []rune(pattern)
+	if len(pat) == 0 {
+		return 0, nil, true
+	}
+	caseSensitive := false
+	for _, r := range pat {
+		if unicode.IsUpper(r) {
+			caseSensitive = true
+			break
+		}
+	}
+	fold := func(r rune) rune {
+		if caseSensitive {
+			return r
+		}
+		return unicode.ToLower(r)
+	}
+
+	runes := []rune(text)
+	folded := make([]rune, len(runes))
+	for i, r := range runes {
+		folded[i] = fold(r)
+	}
+	for i, r := range pat {
+		pat[i] = fold(r)
+	}
+
+	bestScore, matched := 0, false
+	var bestPositions []int
+	tries := 0
+	for from := 0; from < len(folded) && tries < fuzzyMaxStarts; from++ {
+		if folded[from] != pat[0] {
+			continue
+		}
+		tries++
+		positions, ok := fuzzyWindow(folded, pat, from)
+		if !ok {
+			break // no later start can match either
+		}
+		if score := fuzzyScore(runes, positions); !matched || score > bestScore {
+			bestScore, bestPositions, matched = score, positions, true
+		}
+	}
+	return bestScore, bestPositions, matched
+}
+
+func fuzzyWindow(runes, pat []rune, from int) ([]int, bool) {
+	// Forward pass: earliest end of an in-order match.
+	pi, end := 0, -1
+	for i := from; i < len(runes); i++ {
+		if runes[i] == pat[pi] {
+			pi++
+			if pi == len(pat) {
+				end = i
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return nil, false
+	}
+
+	// Backward pass: latest start that still matches, giving the tightest window.
+	pi, start := len(pat)-1, from
+	for i := end; i >= from; i-- {
+		if runes[i] == pat[pi] {
+			pi--
+			if pi < 0 {
+				start = i
+				break
+			}
+		}
+	}
+
+	positions := make([]int, 0, len(pat))
+	pi = 0
+	for i := start; i <= end && pi < len(pat); i++ {
+		if runes[i] == pat[pi] {
+			positions = append(positions, i)
+			pi++
+		}
+	}
+	return positions, true
+}
+
+func fuzzyScore(runes []rune, positions []int) int {
+	score := 0
+	for n, p := range positions {
+		score += fuzzyScoreMatch
+		bonus := fuzzyBoundaryBonus(runes, p)
+		if n == 0 {
+			bonus *= fuzzyBonusFirstChar
+		} else if gap := p - positions[n-1] - 1; gap == 0 {
+			if bonus < fuzzyBonusConsecutive {
+				bonus = fuzzyBonusConsecutive
+			}
+		} else {
+			score -= fuzzyPenaltyGapStart + (gap-1)*fuzzyPenaltyGapExtend
+		}
+		score += bonus
+	}
+	return score
+}
+
+// fuzzyBoundaryBonus rewards matches at the start of a word, after a case
+// change and on CJK characters, which have no word boundaries of their own.
+func fuzzyBoundaryBonus(runes []rune, i int) int {
+	r := runes[i]
+	if isCJK(r) {
+		return fuzzyBonusBoundary / 2
+	}
+	if i == 0 {
+		return fuzzyBonusBoundary
+	}
+	prev := runes[i-1]
+	switch {
+	case !unicode.IsLetter(prev) && !unicode.IsDigit(prev):
+		return fuzzyBonusBoundary
+	case unicode.IsLower(prev) && unicode.IsUpper(r):
+		return fuzzyBonusBoundary - 1
+	case unicode.IsLetter(prev) != unicode.IsLetter(r):
+		return fuzzyBonusBoundary / 2
+	}
+	return 0
+}
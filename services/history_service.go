@@ -1,104 +1,587 @@
 package services
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
 	"clipmini/models"
 )
 
+// HistoryService is safe for concurrent use: the capture goroutine adds
+// items while the UI reads and edits them. Items it returns are snapshots
+// that later edits do not change.
 type HistoryService struct {
+	mu          sync.RWMutex
 	history     *models.History
 	fileService *FileService
+	store       HistoryStore
+	index       *SearchIndex
+	config      *models.AppConfig
+	// refs counts the items per image blob. It is rebuilt from the history
+	// on Load rather than stored, so it cannot drift from it.
+	refs map[string]int
+	// pending counts references taken by SaveImage that no item holds yet
+	pending map[string]int
 }
 
-func NewHistoryService(config *models.AppConfig) *HistoryService {
+func NewHistoryService(config *models.AppConfig, fileService *FileService) *HistoryService {
 	return &HistoryService{
 		history:     models.NewHistory(config.MaxHistoryItems),
-		fileService: NewFileService(config),
+		fileService: fileService,
+		index:       NewSearchIndex(),
+		config:      config,
+		refs:        make(map[string]int),
+		pending:     make(map[string]int),
 	}
 }
 
-func (hs *HistoryService) LoadFromFile() error {
-	lines, err := hs.fileService.ReadHistoryLines()
+// Load opens the configured history store and reads every item into memory,
+// importing history written by an earlier store on first start.
+func (hs *HistoryService) Load() error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	store, err := NewHistoryStore(hs.config, hs.fileService)
+	if err != nil {
+		return err
+	}
+	hs.store = store
+
+	items, err := store.Load()
 	if err != nil {
-		return nil // File might not exist yet, that's ok
+		return err
+	}
+	if len(items) == 0 {
+		if items, err = hs.importPreviousHistory(); err != nil {
+			return err
+		}
+	}
+
+	if err := hs.relocateImages(items); err != nil {
+		return err
+	}
+	if err := hs.migrateImagesToBlobs(items); err != nil {
+		return err
 	}
 
-	hs.history.FromFileFormat(lines)
+	hs.history.Items = items
+	hs.index.Reset(items)
+	hs.refs = make(map[string]int)
+	hs.pending = make(map[string]int)
+	for _, item := range items {
+		hs.ref(item)
+	}
+	hs.maintainLimit()
 	return nil
 }
 
-func (hs *HistoryService) SaveToFile() error {
-	lines := hs.history.ToFileFormat()
-	return hs.fileService.WriteHistoryLines(lines)
+// LoadReadOnly reads the history for a look at it, as by a report-only
+// Fsck, without the imports and migrations Load writes. The history cannot
+// be changed afterwards.
+func (hs *HistoryService) LoadReadOnly() error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	items, err := LoadHistoryReadOnly(hs.config, hs.fileService)
+	if err != nil {
+		return err
+	}
+	hs.history.Items = items
+	hs.index.Reset(items)
+	hs.refs = make(map[string]int)
+	hs.pending = make(map[string]int)
+	for _, item := range items {
+		hs.ref(item)
+	}
+	return nil
+}
+
+// relocateImages points image items at the image directory again after the
+// data directory moved, since their paths are stored absolute.
+func (hs *HistoryService) relocateImages(items []*models.ClipboardItem) error {
+	var moved []*models.ClipboardItem
+	for _, item := range items {
+		if item.Type != models.ClipImage || strings.HasPrefix(item.FilePath, hs.config.ImageDirPath+string(filepath.Separator)) {
+			continue
+		}
+		if _, err := os.Stat(item.FilePath); err == nil {
+			continue
+		}
+		// Blobs keep their shard directory, older images sat at the top
+		shard := filepath.Base(filepath.Dir(item.FilePath))
+		for _, path := range []string{
+			filepath.Join(hs.config.ImageDirPath, shard, filepath.Base(item.FilePath)),
+			filepath.Join(hs.config.ImageDirPath, filepath.Base(item.FilePath)),
+		} {
+			if _, err := os.Stat(path); err == nil {
+				item.FilePath = path
+				moved = append(moved, item)
+				break
+			}
+		}
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+	return hs.store.Put(moved...)
+}
+
+// migrateImagesToBlobs moves images saved under timestamped names into the
+// content-addressed layout, which also merges identical ones.
+func (hs *HistoryService) migrateImagesToBlobs(items []*models.ClipboardItem) error {
+	var moved []*models.ClipboardItem
+	var old []string
+	for _, item := range items {
+		if item.Type != models.ClipImage || item.FilePath == "" || hs.fileService.IsBlobPath(item.FilePath) {
+			continue
+		}
+		data, err := hs.fileService.ReadImage(item.FilePath)
+		if err != nil {
+			continue // left for fsck to report
+		}
+		path, err := hs.fileService.SaveImage(data, strings.TrimPrefix(filepath.Ext(item.FilePath), "."))
+		if err != nil {
+			return err
+		}
+		old = append(old, item.FilePath)
+		item.FilePath = path
+		moved = append(moved, item)
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+	if err := hs.store.Put(moved...); err != nil {
+		return err
+	}
+	hs.fileService.CleanupImageFiles(old)
+	return nil
+}
+
+func (hs *HistoryService) importPreviousHistory() ([]*models.ClipboardItem, error) {
+	h := models.NewHistory(0)
+	var retire func() error
+
+	if _, isJSONL := hs.store.(*JSONLHistoryStore); !isJSONL && hs.fileService.HistoryFileExists() {
+		records, err := hs.fileService.ReadHistoryRecords()
+		if err != nil {
+			return nil, err
+		}
+		if err := h.FromRecords(records); err != nil {
+			return nil, err
+		}
+		retire = hs.fileService.RetireHistoryFile
+	} else if lines, err := hs.fileService.ReadLegacyHistoryLines(); err == nil {
+		h.FromLegacyFormat(lines)
+		retire = hs.fileService.RetireLegacyHistory
+	} else {
+		return nil, nil // Nothing to import, that's ok
+	}
+
+	if len(h.Items) > 0 {
+		if err := hs.store.Put(h.Items...); err != nil {
+			return nil, err
+		}
+	}
+	return h.Items, retire()
+}
+
+func (hs *HistoryService) Close() error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if hs.store == nil {
+		return nil
+	}
+	return hs.store.Close()
 }
 
 func (hs *HistoryService) AddItem(item *models.ClipboardItem) error {
-	hs.history.Add(item)
-	return hs.SaveToFile()
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	dropped := hs.history.Add(item)
+	hs.index.Add(item)
+	hs.ref(item)
+	if err := hs.store.Put(item); err != nil {
+		return err
+	}
+	return hs.dropItems(dropped)
+}
+
+// Supersede replaces the item with the given ID by item, a newer capture of
+// the same image. item moves to the top and takes over the ID, pin and
+// labels, so the list can swap the row in place.
+func (hs *HistoryService) Supersede(id string, item *models.ClipboardItem) error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	old := hs.history.RemoveItem(hs.history.IndexOf(id))
+	if old != nil {
+		item.ID, item.Pinned, item.Collection, item.Tags = old.ID, old.Pinned, old.Collection, old.Tags
+		hs.index.Remove(old.ID)
+	}
+
+	dropped := hs.history.Add(item)
+	hs.index.Add(item)
+	// Count the new image before releasing the old one, they may be the same
+	hs.ref(item)
+	if old != nil && hs.unref(old) {
+		hs.fileService.CleanupImageFiles([]string{old.FilePath})
+	}
+	if err := hs.store.Put(item); err != nil {
+		return err
+	}
+	return hs.dropItems(dropped)
 }
 
 func (hs *HistoryService) GetItems() []*models.ClipboardItem {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
 	return hs.history.GetItems()
 }
 
-func (hs *HistoryService) GetItem(index int) *models.ClipboardItem {
-	return hs.history.GetItem(index)
+func (hs *HistoryService) GetItem(id string) *models.ClipboardItem {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	return hs.history.GetItem(hs.history.IndexOf(id))
 }
 
-func (hs *HistoryService) RemoveItem(index int) error {
-	removedItem := hs.history.RemoveItem(index)
-	if removedItem == nil {
-		return nil
+func (hs *HistoryService) Search(query string, mode SearchMode) []*models.ClipboardItem {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	return hs.index.Search(query, mode)
+}
+
+// Query evaluates a parsed query, letting the search index narrow down the
+// candidates for literal terms first.
+func (hs *HistoryService) Query(q *Query) []*models.ClipboardItem {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	candidates := hs.history.GetItems()
+	substring, wholeWord := q.indexTokens()
+
+	var allowed map[string]struct{}
+	narrow := func(found []*models.ClipboardItem) {
+		ids := make(map[string]struct{}, len(found))
+		for _, item := range found {
+			ids[item.ID] = struct{}{}
+		}
+		allowed = intersectIDs(allowed, ids)
+	}
+	for _, text := range substring {
+		narrow(hs.index.Search(text, SearchSubstring))
+	}
+	for _, word := range wholeWord {
+		narrow(hs.index.Search(word, SearchWholeWord))
+	}
+
+	if allowed != nil {
+		narrowed := make([]*models.ClipboardItem, 0, len(allowed))
+		for _, item := range candidates {
+			if _, ok := allowed[item.ID]; ok {
+				narrowed = append(narrowed, item)
+			}
+		}
+		candidates = narrowed
 	}
+	return q.Filter(candidates)
+}
 
-	if removedItem.Type == models.ClipImage && removedItem.FilePath != "" {
-		hs.fileService.CleanupImageFiles([]string{removedItem.FilePath})
+func (hs *HistoryService) RemoveItem(id string) error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	removedItem := hs.history.RemoveItem(hs.history.IndexOf(id))
+	if removedItem == nil {
+		return nil
 	}
 
-	return hs.SaveToFile()
+	return hs.dropItems([]*models.ClipboardItem{removedItem})
 }
 
-func (hs *HistoryService) UpdateItem(index int, newContent string) error {
+func (hs *HistoryService) UpdateItem(id string, newContent string) error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	index := hs.history.IndexOf(id)
 	if hs.history.UpdateItem(index, newContent) {
-		return hs.SaveToFile()
+		item := hs.history.GetItem(index)
+		hs.index.Add(item)
+		return hs.store.Put(item)
 	}
 	return nil
 }
 
-func (hs *HistoryService) Clear() error {
+func (hs *HistoryService) SetPinned(id string, pinned bool) error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	index := hs.history.IndexOf(id)
+	if !hs.history.SetPinned(index, pinned) {
+		return nil
+	}
+	if err := hs.store.Put(hs.history.GetItem(index)); err != nil {
+		return err
+	}
+	if !pinned {
+		hs.maintainLimit()
+	}
+	return nil
+}
+
+// RemoveExpired deletes items whose expiry or retention deadline has passed,
+// image files included, and returns them.
+func (hs *HistoryService) RemoveExpired(policy *RetentionPolicy, now time.Time) ([]*models.ClipboardItem, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	removed := hs.history.RemoveWhere(func(item *models.ClipboardItem) bool {
+		return policy.Expired(item, now)
+	})
+	return removed, hs.dropItems(removed)
+}
+
+func (hs *HistoryService) SetLabels(id string, collection string, tags []string) error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	index := hs.history.IndexOf(id)
+	if !hs.history.SetLabels(index, collection, tags) {
+		return nil
+	}
+	return hs.store.Put(hs.history.GetItem(index))
+}
+
+func (hs *HistoryService) Collections() []string {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	return hs.history.Collections()
+}
+
+// ImportItems merges items from elsewhere, e.g. a shared collection, into the
+// history by timestamp.
+func (hs *HistoryService) ImportItems(items []*models.ClipboardItem) error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if len(items) == 0 {
+		return nil
+	}
+	dropped := hs.history.Merge(items)
+	for _, item := range items {
+		hs.index.Add(item)
+		hs.ref(item)
+	}
+	if err := hs.store.Put(items...); err != nil {
+		return err
+	}
+	return hs.dropItems(dropped)
+}
+
+// Clear removes every unpinned item. With includePinned it wipes the whole
+// history, store and image directory included.
+func (hs *HistoryService) Clear(includePinned bool) error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	removed := hs.history.Clear(includePinned)
+	if !includePinned {
+		return hs.dropItems(removed)
+	}
+
 	// Clean up image files first
 	imagePaths := make([]string, 0)
-	for _, item := range hs.history.GetItems() {
+	for _, item := range removed {
 		if item.Type == models.ClipImage && item.FilePath != "" {
 			imagePaths = append(imagePaths, item.FilePath)
 		}
 	}
 	hs.fileService.CleanupImageFiles(imagePaths)
+	hs.index.Reset(nil)
+	hs.refs = make(map[string]int)
+	hs.pending = make(map[string]int)
 
-	// Clear history
-	hs.history.Clear()
-
-	// Delete files
-	hs.fileService.DeleteHistoryFile()
 	hs.fileService.DeleteImageDirectory()
+	hs.fileService.DeleteThumbnails()
+	return hs.store.Clear()
+}
 
-	return nil
+// Rekey seals the store and every saved image with key. Plaintext data is
+// encrypted the same way, which is how an existing history is migrated when
+// encryption is first enabled.
+//
+// Everything is first written next to the originals, sealed with the new
+// key. commit then makes the new key info current, and only after that are
+// the copies swapped in, so an interruption at any point leaves data that
+// FileService.RecoverRekey can make readable with one of the keys.
+func (hs *HistoryService) Rekey(key []byte, commit func() error) error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	staging := NewVault()
+	if err := staging.SetKey(key); err != nil {
+		return err
+	}
+
+	// Images move to names keyed with the new key; see Vault.BlobName
+	moved := make(map[string]string)
+	for _, item := range hs.history.Items {
+		if item.Type != models.ClipImage || item.FilePath == "" {
+			continue
+		}
+		if _, ok := moved[item.FilePath]; ok {
+			continue
+		}
+		data, err := hs.fileService.ReadImage(item.FilePath)
+		if err != nil {
+			continue // missing, left for fsck to report
+		}
+		path, err := hs.fileService.StageImage(item.FilePath, data, staging)
+		if err != nil {
+			return err
+		}
+		moved[item.FilePath] = path
+	}
+	if err := hs.fileService.StageRenames(moved); err != nil {
+		return err
+	}
+	items := make([]*models.ClipboardItem, len(hs.history.Items))
+	for i, item := range hs.history.Items {
+		if path, ok := moved[item.FilePath]; ok && path != item.FilePath {
+			renamed := *item
+			renamed.FilePath = path
+			item = &renamed
+		}
+		items[i] = item
+	}
+	if err := hs.store.StageRewrite(staging, items...); err != nil {
+		return err
+	}
+
+	if err := commit(); err != nil {
+		return err
+	}
+	if err := hs.fileService.Vault().SetKey(key); err != nil {
+		return err
+	}
+	hs.history.Items = items
+	hs.index.Reset(items)
+	hs.refs = make(map[string]int)
+	for _, item := range items {
+		hs.ref(item)
+	}
+	// Thumbnails were sealed with the old key, or not at all
+	hs.fileService.DeleteThumbnails()
+	if err := hs.fileService.CommitImages(moved); err != nil {
+		return err
+	}
+	return hs.store.CommitRewrite()
+}
+
+// SetMaxItems changes the count limit and trims the history to it.
+func (hs *HistoryService) SetMaxItems(n int) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.history.MaxItems = n
+	hs.maintainLimit()
 }
 
 func (hs *HistoryService) MaintainLimit() {
-	items := hs.history.GetItems()
-	if len(items) > hs.history.MaxItems {
-		// Clean up excess images
-		excessItems := items[hs.history.MaxItems:]
-		imagePaths := make([]string, 0)
-		for _, item := range excessItems {
-			if item.Type == models.ClipImage && item.FilePath != "" {
-				imagePaths = append(imagePaths, item.FilePath)
-			}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.maintainLimit()
+}
+
+func (hs *HistoryService) maintainLimit() {
+	hs.dropItems(hs.history.Trim())
+}
+
+// dropItems deletes items that already left the in-memory history from the
+// store, together with the image blobs no other item references.
+func (hs *HistoryService) dropItems(items []*models.ClipboardItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(items))
+	imagePaths := make([]string, 0)
+	for _, item := range items {
+		ids = append(ids, item.ID)
+		hs.index.Remove(item.ID)
+		if hs.unref(item) {
+			imagePaths = append(imagePaths, item.FilePath)
 		}
-		hs.fileService.CleanupImageFiles(imagePaths)
+	}
+	hs.fileService.CleanupImageFiles(imagePaths)
+	return hs.store.Delete(ids...)
+}
+
+// SaveImage stores the image of an item about to be added and references
+// the blob until AddItem or Supersede takes the reference over. Reusing an
+// existing blob and referencing it happen under one lock, so removing
+// another item cannot delete the blob in between.
+func (hs *HistoryService) SaveImage(data []byte, ext string) (string, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	path, err := hs.fileService.SaveImage(data, ext)
+	if err != nil {
+		return "", err
+	}
+	hs.refs[path]++
+	hs.pending[path]++
+	return path, nil
+}
 
-		// Trim history
-		hs.history.Items = items[:hs.history.MaxItems]
-		hs.SaveToFile()
+func (hs *HistoryService) ref(item *models.ClipboardItem) {
+	if item.Type != models.ClipImage || item.FilePath == "" {
+		return
+	}
+	if n := hs.pending[item.FilePath]; n > 0 {
+		// Counted by SaveImage already
+		if n == 1 {
+			delete(hs.pending, item.FilePath)
+		} else {
+			hs.pending[item.FilePath] = n - 1
+		}
+		return
+	}
+	hs.refs[item.FilePath]++
+}
+
+// unref reports whether item held the last reference to its image.
+func (hs *HistoryService) unref(item *models.ClipboardItem) bool {
+	if item.Type != models.ClipImage || item.FilePath == "" {
+		return false
+	}
+	hs.refs[item.FilePath]--
+	if hs.refs[item.FilePath] > 0 {
+		return false
+	}
+	delete(hs.refs, item.FilePath)
+	return true
+}
+
+// FsckReport lists where the image files and the history disagree.
+type FsckReport struct {
+	Missing  []*models.ClipboardItem // image items whose file is gone
+	Orphaned []string                // files in the image directory no item references
+}
+
+// Fsck compares the image directory with the history. With prune it
+// deletes the orphaned files; items with missing images are only reported.
+func (hs *HistoryService) Fsck(prune bool) (*FsckReport, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	report := &FsckReport{}
+	for _, item := range hs.history.Items {
+		if item.Type == models.ClipImage && !hs.fileService.ImageExists(item.FilePath) {
+			report.Missing = append(report.Missing, item)
+		}
+	}
+	files, err := hs.fileService.ListImageFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		if hs.refs[path] == 0 {
+			report.Orphaned = append(report.Orphaned, path)
+		}
+	}
+	if prune {
+		hs.fileService.CleanupImageFiles(report.Orphaned)
 	}
+	return report, nil
 }
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"clipmini/models"
+)
+
+func newTestHistory(t *testing.T) (*HistoryService, *models.AppConfig) {
+	t.Helper()
+	dir := t.TempDir()
+	config, err := models.LoadAppConfig(models.ResolveAppDirs(models.AppDirs{Data: dir, Config: dir, Cache: dir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := NewHistoryService(config, NewFileService(config))
+	if err := hs.Load(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hs.Close() })
+	return hs, config
+}
+
+// TestHistoryServiceConcurrentEdits runs the calls the capture goroutine and
+// the UI make at the same time; run it with -race.
+func TestHistoryServiceConcurrentEdits(t *testing.T) {
+	hs, _ := newTestHistory(t)
+	image := screenshot(t, "png")
+
+	const workers, rounds = 4, 25
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				item := models.NewTextItem(fmt.Sprintf("text %d-%d", w, i))
+				if err := hs.AddItem(item); err != nil {
+					t.Error(err)
+					return
+				}
+				if i%2 == 0 {
+					if err := hs.RemoveItem(item.ID); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			// Every worker saves the same image, so the blob is shared
+			for i := 0; i < rounds; i++ {
+				path, err := hs.SaveImage(image, "png")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				item := models.NewImageItem(path)
+				items := hs.GetItems()
+				if len(items) > 0 && items[0].Type == models.ClipImage {
+					err = hs.Supersede(items[0].ID, item)
+				} else {
+					err = hs.AddItem(item)
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				for _, item := range hs.GetItems() {
+					if item.Type == models.ClipImage && i%5 == 0 {
+						hs.RemoveItem(item.ID)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The shared blob must survive exactly as long as an item points at it
+	report, err := hs.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Missing) > 0 || len(report.Orphaned) > 0 {
+		t.Fatalf("fsck found %d missing and %d orphaned images", len(report.Missing), len(report.Orphaned))
+	}
+}
+
+func TestSaveImageRejectsBadExtension(t *testing.T) {
+	hs, _ := newTestHistory(t)
+	for _, ext := range []string{"", ".png", "png/x", `a\b`} {
+		if path, err := hs.SaveImage([]byte("image"), ext); err == nil {
+			t.Errorf("SaveImage(ext %q) = %s, want an error", ext, path)
+		}
+	}
+}
+
+func TestFsckKeepsStagedRekeyFiles(t *testing.T) {
+	hs, _ := newTestHistory(t)
+	path, err := hs.SaveImage([]byte("image"), "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hs.AddItem(models.NewImageItem(path)); err != nil {
+		t.Fatal(err)
+	}
+	staged := path + rekeySuffix
+	if err := os.WriteFile(staged, []byte("staged"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := hs.Fsck(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphaned) > 0 {
+		t.Errorf("orphaned: %v, want none", report.Orphaned)
+	}
+	if _, err := os.Stat(staged); err != nil {
+		t.Errorf("pruning removed the staged copy: %v", err)
+	}
+}
+
+func TestLoadReadOnlyWritesNothing(t *testing.T) {
+	for _, store := range []string{"jsonl", "bolt"} {
+		t.Run(store, func(t *testing.T) {
+			dir := t.TempDir()
+			config, err := models.LoadAppConfig(models.ResolveAppDirs(models.AppDirs{Data: dir, Config: dir, Cache: dir}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.HistoryStore = store
+			// A legacy history that Load would import and retire
+			legacy := "2024-01-02 03:04:05\tTEXT\thello\n"
+			if err := os.WriteFile(config.LegacyLogPath, []byte(legacy), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			hs := NewHistoryService(config, NewFileService(config))
+			if err := hs.LoadReadOnly(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := hs.Fsck(false); err != nil {
+				t.Fatal(err)
+			}
+			hs.Close()
+
+			if data, err := os.ReadFile(config.LegacyLogPath); err != nil || string(data) != legacy {
+				t.Errorf("legacy history changed: %q, %v", data, err)
+			}
+			for _, path := range []string{config.LogFilePath, config.HistoryDBPath} {
+				if _, err := os.Stat(path); err == nil {
+					t.Errorf("%s was created", path)
+				}
+			}
+		})
+	}
+}
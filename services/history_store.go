@@ -0,0 +1,168 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"clipmini/models"
+)
+
+// HistoryStore persists clipboard items incrementally.
+type HistoryStore interface {
+	// Load returns every stored item, newest first.
+	Load() ([]*models.ClipboardItem, error)
+	// Put inserts the items or replaces the stored items with the same ID.
+	Put(items ...*models.ClipboardItem) error
+	Delete(ids ...string) error
+	// StageRewrite writes items, sealed with vault, to a copy of the store
+	// next to it, and CommitRewrite swaps that copy in. They are used to
+	// change the encryption key; see HistoryService.Rekey.
+	StageRewrite(vault *Vault, items ...*models.ClipboardItem) error
+	CommitRewrite() error
+	Clear() error
+	Close() error
+}
+
+func NewHistoryStore(config *models.AppConfig, fileService *FileService) (HistoryStore, error) {
+	switch config.HistoryStore {
+	case "", "jsonl":
+		return NewJSONLHistoryStore(fileService), nil
+	case "bolt":
+		return OpenBoltHistoryStore(config.HistoryDBPath, fileService.Vault())
+	default:
+		return nil, fmt.Errorf("unknown history store %q", config.HistoryStore)
+	}
+}
+
+// LoadHistoryReadOnly reads the items of the configured store, newest first,
+// without changing anything on disk: no store is created and no old data
+// migrated.
+func LoadHistoryReadOnly(config *models.AppConfig, fileService *FileService) ([]*models.ClipboardItem, error) {
+	switch config.HistoryStore {
+	case "", "jsonl":
+		if !fileService.HistoryFileExists() {
+			return nil, nil
+		}
+		records, err := fileService.ReadHistoryRecords()
+		if err != nil {
+			return nil, err
+		}
+		h := models.NewHistory(0)
+		err = h.FromRecords(records)
+		return h.Items, err
+	case "bolt":
+		return loadBoltHistoryReadOnly(config.HistoryDBPath, fileService.Vault())
+	default:
+		return nil, fmt.Errorf("unknown history store %q", config.HistoryStore)
+	}
+}
+
+// JSONLHistoryStore keeps the whole history in a JSON Lines file and rewrites
+// it on every change. It is simple and diffable but O(n) per write, so large
+// histories belong in the bolt store; see BenchmarkHistoryAddItem.
+type JSONLHistoryStore struct {
+	fileService *FileService
+	records     map[string]models.HistoryRecord
+	staged      map[string]models.HistoryRecord // written by StageRewrite
+}
+
+func NewJSONLHistoryStore(fileService *FileService) *JSONLHistoryStore {
+	return &JSONLHistoryStore{
+		fileService: fileService,
+		records:     make(map[string]models.HistoryRecord),
+	}
+}
+
+func (js *JSONLHistoryStore) Load() ([]*models.ClipboardItem, error) {
+	js.records = make(map[string]models.HistoryRecord)
+	if !js.fileService.HistoryFileExists() {
+		return nil, nil
+	}
+
+	records, err := js.fileService.ReadHistoryRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	h := models.NewHistory(0)
+	if err := h.FromRecords(records); err != nil {
+		return nil, err
+	}
+	for _, item := range h.Items {
+		js.records[item.ID] = item.ToRecord()
+	}
+	// Files written before schema v2 carry local time stamps; store them
+	// as UTC once.
+	for _, rec := range records {
+		if !rec.IsUTC() {
+			if err := js.flush(); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return h.Items, nil
+}
+
+func (js *JSONLHistoryStore) Put(items ...*models.ClipboardItem) error {
+	for _, item := range items {
+		js.records[item.ID] = item.ToRecord()
+	}
+	return js.flush()
+}
+
+func (js *JSONLHistoryStore) Delete(ids ...string) error {
+	for _, id := range ids {
+		delete(js.records, id)
+	}
+	return js.flush()
+}
+
+func (js *JSONLHistoryStore) StageRewrite(vault *Vault, items ...*models.ClipboardItem) error {
+	js.staged = make(map[string]models.HistoryRecord, len(items))
+	for _, item := range items {
+		js.staged[item.ID] = item.ToRecord()
+	}
+	return js.fileService.writeHistoryRecords(js.fileService.config.LogFilePath+rekeySuffix, sortRecords(js.staged), vault)
+}
+
+func (js *JSONLHistoryStore) CommitRewrite() error {
+	path := js.fileService.config.LogFilePath
+	if err := os.Rename(path+rekeySuffix, path); err != nil {
+		return err
+	}
+	js.records, js.staged = js.staged, nil
+	return nil
+}
+
+func (js *JSONLHistoryStore) Clear() error {
+	js.records = make(map[string]models.HistoryRecord)
+	if err := js.fileService.DeleteHistoryFile(); err != nil && js.fileService.HistoryFileExists() {
+		return err
+	}
+	return nil
+}
+
+func (js *JSONLHistoryStore) Close() error {
+	return nil
+}
+
+func (js *JSONLHistoryStore) flush() error {
+	return js.fileService.WriteHistoryRecords(sortRecords(js.records))
+}
+
+// sortRecords returns the records oldest first, the order of the file.
+func sortRecords(byID map[string]models.HistoryRecord) []models.HistoryRecord {
+	records := make([]models.HistoryRecord, 0, len(byID))
+	for _, rec := range byID {
+		records = append(records, rec)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Timestamp.Equal(records[j].Timestamp) {
+			return records[i].ID < records[j].ID
+		}
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+	return records
+}
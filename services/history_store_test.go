@@ -0,0 +1,244 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"clipmini/models"
+)
+
+var historyStores = []string{"jsonl", "bolt"}
+
+func newTestStoreConfig(t testing.TB, store string) *models.AppConfig {
+	t.Helper()
+	dir := t.TempDir()
+	config, err := models.LoadAppConfig(models.ResolveAppDirs(models.AppDirs{Data: dir, Config: dir, Cache: dir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.HistoryStore = store
+	return config
+}
+
+// openTestStore opens the configured store and loads it, the way
+// HistoryService.Load does.
+func openTestStore(t testing.TB, config *models.AppConfig) (HistoryStore, []*models.ClipboardItem) {
+	t.Helper()
+	store, err := NewHistoryStore(config, NewFileService(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	items, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return store, items
+}
+
+// storeTestItems returns n text items, one minute apart, oldest first.
+func storeTestItems(n int) []*models.ClipboardItem {
+	base := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	items := make([]*models.ClipboardItem, n)
+	for i := range items {
+		items[i] = models.NewTextItem("item " + strconv.Itoa(i))
+		items[i].Timestamp = base.Add(time.Duration(i) * time.Minute)
+	}
+	return items
+}
+
+func itemIDs(items []*models.ClipboardItem) []string {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	return ids
+}
+
+func TestHistoryStoreRoundTrip(t *testing.T) {
+	for _, name := range historyStores {
+		t.Run(name, func(t *testing.T) {
+			config := newTestStoreConfig(t, name)
+			text := models.NewTextItem("hello\nworld")
+			text.Timestamp = time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+			text.Pinned = true
+			text.Collection = "work"
+			text.Tags = []string{"a", "b"}
+			text.ExpiresAt = text.Timestamp.Add(time.Hour)
+			image := models.NewImageItem("/data/images/ab/abcdef.png")
+			image.Timestamp = text.Timestamp.Add(time.Second)
+			image.Original = &models.ImageInfo{Format: "jpeg", Width: 640, Height: 480}
+			image.PHash = "0123456789abcdef"
+
+			store, _ := openTestStore(t, config)
+			if err := store.Put(text, image); err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+
+			_, got := openTestStore(t, config)
+			want := []*models.ClipboardItem{image, text}
+			if len(got) != len(want) {
+				t.Fatalf("got %d items, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if !reflect.DeepEqual(got[i].ToRecord(), want[i].ToRecord()) {
+					t.Errorf("item %d = %+v, want %+v", i, got[i].ToRecord(), want[i].ToRecord())
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryStoreDelete(t *testing.T) {
+	for _, name := range historyStores {
+		t.Run(name, func(t *testing.T) {
+			config := newTestStoreConfig(t, name)
+			items := storeTestItems(4)
+			store, _ := openTestStore(t, config)
+			if err := store.Put(items...); err != nil {
+				t.Fatal(err)
+			}
+			// Deleting an unknown ID is not an error
+			if err := store.Delete(items[1].ID, items[2].ID, "missing"); err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+
+			_, got := openTestStore(t, config)
+			want := []string{items[3].ID, items[0].ID}
+			if ids := itemIDs(got); !reflect.DeepEqual(ids, want) {
+				t.Fatalf("after delete got %v, want %v", ids, want)
+			}
+		})
+	}
+}
+
+// TestHistoryStoreOrdersByTime loads items newest first whatever the order
+// they were put in, and moves a replaced item to its new time.
+func TestHistoryStoreOrdersByTime(t *testing.T) {
+	for _, name := range historyStores {
+		t.Run(name, func(t *testing.T) {
+			config := newTestStoreConfig(t, name)
+			items := storeTestItems(5)
+			store, _ := openTestStore(t, config)
+			for _, i := range []int{2, 0, 4, 1, 3} {
+				if err := store.Put(items[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// The oldest item is copied again
+			moved := *items[0]
+			moved.Timestamp = items[4].Timestamp.Add(time.Minute)
+			if err := store.Put(&moved); err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+
+			_, got := openTestStore(t, config)
+			want := []string{items[0].ID, items[4].ID, items[3].ID, items[2].ID, items[1].ID}
+			if ids := itemIDs(got); !reflect.DeepEqual(ids, want) {
+				t.Fatalf("got %v, want %v", ids, want)
+			}
+			if !got[0].Timestamp.Equal(moved.Timestamp) {
+				t.Errorf("moved item has time %v, want %v", got[0].Timestamp, moved.Timestamp)
+			}
+		})
+	}
+}
+
+// TestHistoryStoreReopen keeps writing to a store after opening it again,
+// and clears it.
+func TestHistoryStoreReopen(t *testing.T) {
+	for _, name := range historyStores {
+		t.Run(name, func(t *testing.T) {
+			config := newTestStoreConfig(t, name)
+			items := storeTestItems(3)
+			for i, item := range items {
+				store, got := openTestStore(t, config)
+				if len(got) != i {
+					t.Fatalf("open %d: got %d items, want %d", i, len(got), i)
+				}
+				if err := store.Put(item); err != nil {
+					t.Fatal(err)
+				}
+				store.Close()
+			}
+
+			store, got := openTestStore(t, config)
+			want := []string{items[2].ID, items[1].ID, items[0].ID}
+			if ids := itemIDs(got); !reflect.DeepEqual(ids, want) {
+				t.Fatalf("got %v, want %v", ids, want)
+			}
+			if err := store.Clear(); err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+			if _, got := openTestStore(t, config); len(got) != 0 {
+				t.Fatalf("got %d items after Clear, want none", len(got))
+			}
+		})
+	}
+}
+
+func TestBoltHistoryStoreRefusesNewerSchema(t *testing.T) {
+	config := newTestStoreConfig(t, "bolt")
+	store, _ := openTestStore(t, config)
+	store.Close()
+
+	db, err := bolt.Open(config.HistoryDBPath, 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMetaBucket).Put(boltVersionKey, []byte(strconv.Itoa(models.HistorySchemaVersion+1)))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if store, err := NewHistoryStore(config, NewFileService(config)); err == nil {
+		store.Close()
+		t.Fatal("opened a database of a newer schema")
+	}
+}
+
+// BenchmarkHistoryAddItem adds items to a history that already holds tens of
+// thousands, trimming the oldest, as the capture loop does. Each operation
+// must stay far below the poll interval.
+func BenchmarkHistoryAddItem(b *testing.B) {
+	const size = 50000
+	for _, name := range historyStores {
+		b.Run(fmt.Sprintf("%s/%d", name, size), func(b *testing.B) {
+			config := newTestStoreConfig(b, name)
+			config.MaxHistoryItems = size
+			store, _ := openTestStore(b, config)
+			if err := store.Put(storeTestItems(size)...); err != nil {
+				b.Fatal(err)
+			}
+			store.Close()
+
+			hs := NewHistoryService(config, NewFileService(config))
+			if err := hs.Load(); err != nil {
+				b.Fatal(err)
+			}
+			defer hs.Close()
+			if n := len(hs.GetItems()); n != size {
+				b.Fatalf("loaded %d items, want %d", n, size)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := hs.AddItem(models.NewTextItem("new " + strconv.Itoa(i))); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
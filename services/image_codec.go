@@ -0,0 +1,39 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+
+	_ "image/gif"
+	_ "image/jpeg"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp" // decode only, images are stored as png or jpeg
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func isPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+// toPNG returns data unchanged when it already is a PNG and re-encodes any
+// other decodable image, such as the TIFF macOS puts on the pasteboard.
+func toPNG(data []byte) ([]byte, error) {
+	if isPNG(data) {
+		return data, nil
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode clipboard image: %w", err)
+	}
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("encode %s as png: %w", format, err)
+	}
+	return buf.Bytes(), nil
+}
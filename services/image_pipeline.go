@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+
+	"golang.org/x/image/draw"
+
+	"clipmini/models"
+)
+
+const (
+	// minImageSide stops MaxBytes from shrinking an image into a smudge.
+	minImageSide = 64
+	// maxImagePixels refuses images that would take gigabytes to decode;
+	// it is well above two 8K screens.
+	maxImagePixels = 80_000_000
+)
+
+// fallbackJPEGQualities are tried in turn when an image shrunk to
+// minImageSide is still over MaxBytes.
+var fallbackJPEGQualities = []int{60, 40, 20}
+
+// NormalizedImage is a captured image ready to be stored.
+type NormalizedImage struct {
+	Data     []byte
+	Ext      string // file extension matching Data
+	Original models.ImageInfo
+	PHash    string // perceptual hash of the original, see PerceptualHash
+}
+
+// NormalizeImage sniffs the real format of data, which need not match what
+// the clipboard claimed, and re-encodes it to the configured storage format
+// within the size limits. Data already in that format and within the limits
+// is kept byte for byte. The image is decoded once, also for its perceptual
+// hash. An image that is still over MaxBytes at minImageSide is stored as a
+// lower quality JPEG, or refused when even that is too large.
+func NormalizeImage(data []byte, config models.ImageConfig) (*NormalizedImage, error) {
+	conf, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unrecognized image: %w", err)
+	}
+	if pixels := int64(conf.Width) * int64(conf.Height); pixels > maxImagePixels {
+		return nil, fmt.Errorf("%s image of %d×%d pixels is too large", format, conf.Width, conf.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", format, err)
+	}
+	out := &NormalizedImage{
+		Ext:      imageExt(config.Format),
+		Original: models.ImageInfo{Format: format, Width: conf.Width, Height: conf.Height},
+		PHash:    perceptualHash(img),
+	}
+	if format == config.Format && scaleToFit(conf.Width, conf.Height, config.MaxDimension) == 1 &&
+		(config.MaxBytes == 0 || len(data) <= config.MaxBytes) {
+		out.Data = data
+		return out, nil
+	}
+
+	if scale := scaleToFit(conf.Width, conf.Height, config.MaxDimension); scale < 1 {
+		img = resizeImage(img, scale)
+	}
+	for {
+		if out.Data, err = encodeImage(img, config); err != nil {
+			return nil, err
+		}
+		if config.MaxBytes == 0 || len(out.Data) <= config.MaxBytes {
+			return out, nil
+		}
+		b := img.Bounds()
+		if max(b.Dx(), b.Dy()) <= minImageSide {
+			break
+		}
+		// Encoded size grows roughly with the pixel count
+		scale := math.Sqrt(float64(config.MaxBytes)/float64(len(out.Data))) * 0.95
+		img = resizeImage(img, min(max(scale, 0.5), 0.9))
+	}
+
+	// Too small to shrink further: trade quality for size instead
+	jpegConfig := config
+	jpegConfig.Format = models.ImageFormatJPEG
+	for _, quality := range fallbackJPEGQualities {
+		if quality >= config.JPEGQuality && config.Format == models.ImageFormatJPEG {
+			continue
+		}
+		jpegConfig.JPEGQuality = quality
+		if out.Data, err = encodeImage(img, jpegConfig); err != nil {
+			return nil, err
+		}
+		if len(out.Data) <= config.MaxBytes {
+			out.Ext = imageExt(jpegConfig.Format)
+			return out, nil
+		}
+	}
+	return nil, fmt.Errorf("%s image of %d×%d pixels does not fit in %d bytes", format, conf.Width, conf.Height, config.MaxBytes)
+}
+
+func imageExt(format string) string {
+	if format == models.ImageFormatJPEG {
+		return "jpg"
+	}
+	return "png"
+}
+
+// scaleToFit returns the factor that brings the longest side down to
+// maxSide, or 1 when it already fits or there is no limit.
+func scaleToFit(width, height, maxSide int) float64 {
+	longest := max(width, height)
+	if maxSide <= 0 || longest <= maxSide {
+		return 1
+	}
+	return float64(maxSide) / float64(longest)
+}
+
+func resizeImage(img image.Image, scale float64) image.Image {
+	b := img.Bounds()
+	w := max(1, int(math.Round(float64(b.Dx())*scale)))
+	h := max(1, int(math.Round(float64(b.Dy())*scale)))
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func encodeImage(img image.Image, config models.ImageConfig) ([]byte, error) {
+	var buf bytes.Buffer
+	if config.Format == models.ImageFormatJPEG {
+		// JPEG has no alpha; flatten onto white rather than black
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: config.JPEGQuality}); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
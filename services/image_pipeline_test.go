@@ -0,0 +1,189 @@
+package services
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/tiff"
+
+	"clipmini/models"
+)
+
+// screenshot draws a 1440×900 image with flat areas and sharp edges, like a
+// window capture, encoded as format.
+func screenshot(tb testing.TB, format string) []byte {
+	tb.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 1440, 900))
+	for y := 0; y < 900; y++ {
+		for x := 0; x < 1440; x++ {
+			c := color.RGBA{0xf5, 0xf5, 0xf7, 0xff}
+			switch {
+			case y < 40:
+				c = color.RGBA{0xe0, 0xe0, 0xe4, 0xff}
+			case x < 260:
+				c = color.RGBA{0x2c, 0x2c, 0x34, 0xff}
+			case y%24 < 12 && (x*7+y)%97 < 60:
+				c = color.RGBA{uint8(x), uint8(y), 0x40, 0xff}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "tiff":
+		err = tiff.Encode(&buf, img, nil)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	}
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// noise returns a size×size PNG of random pixels, which compresses badly.
+func noise(tb testing.TB, size int) []byte {
+	tb.Helper()
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	rng.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestNormalizeImageMaxBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		maxBytes int
+		wantExt  string // empty when the image must be refused
+	}{
+		{"shrinks", screenshot(t, "png"), 20000, "png"},
+		{"falls back to jpeg", noise(t, 256), 4000, "jpg"},
+		{"refuses", noise(t, 256), 200, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.NewImageConfig()
+			config.MaxBytes = tt.maxBytes
+			img, err := NormalizeImage(tt.data, config)
+			if tt.wantExt == "" {
+				if err == nil {
+					t.Fatalf("got %d bytes over a limit of %d, want an error", len(img.Data), tt.maxBytes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(img.Data) > tt.maxBytes {
+				t.Errorf("got %d bytes, want at most %d", len(img.Data), tt.maxBytes)
+			}
+			_, format, err := image.DecodeConfig(bytes.NewReader(img.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Ext != tt.wantExt || imageExt(format) != img.Ext {
+				t.Errorf("got %s data with extension %s, want %s", format, img.Ext, tt.wantExt)
+			}
+		})
+	}
+}
+
+var benchFormats = []string{"png", "tiff", "jpeg"}
+
+func BenchmarkNormalizeImage(b *testing.B) {
+	config := models.NewImageConfig()
+	for _, format := range benchFormats {
+		data := screenshot(b, format)
+		b.Run(format, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				if _, err := NormalizeImage(data, config); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkToPNG(b *testing.B) {
+	for _, format := range benchFormats {
+		data := screenshot(b, format)
+		b.Run(format, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				if _, err := toPNG(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkMacImageRead compares what happens in Go after osascript returns
+// a captured image. AppleScript used to print it as «data PNGf…» hex that
+// was parsed byte by byte; the JXA script writes the raw bytes.
+func BenchmarkMacImageRead(b *testing.B) {
+	config := models.NewImageConfig()
+	data := screenshot(b, "png")
+	script := []byte("«data PNGf" + strings.ToUpper(hex.EncodeToString(data)) + "»\n")
+
+	b.Run("applescript-hex", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for b.Loop() {
+			decoded, err := decodeAppleScriptData(script, "PNGf")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := NormalizeImage(decoded, config); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("raw", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for b.Loop() {
+			if _, err := NormalizeImage(data, config); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// decodeAppleScriptData is how ReadImage parsed osascript output before the
+// raw-bytes scripts.
+func decodeAppleScriptData(out []byte, typ string) ([]byte, error) {
+	hexStr := strings.TrimSpace(string(out))
+	hexStr = strings.TrimPrefix(hexStr, "«data "+typ)
+	hexStr = strings.TrimSuffix(hexStr, "»")
+	hexStr = strings.ReplaceAll(hexStr, " ", "")
+	if len(hexStr)%2 != 0 {
+		return nil, fmt.Errorf("bad hex length")
+	}
+	data := make([]byte, len(hexStr)/2)
+	for i := 0; i < len(hexStr); i += 2 {
+		var b byte
+		fmt.Sscanf(hexStr[i:i+2], "%02x", &b)
+		data[i/2] = b
+	}
+	return data, nil
+}
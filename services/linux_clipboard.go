@@ -0,0 +1,208 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type linuxClipboardTool int
+
+const (
+	toolWayland linuxClipboardTool = iota
+	toolXclip
+	toolXsel
+)
+
+// LinuxClipboardBackend drives wl-clipboard on Wayland and xclip/xsel on X11.
+type LinuxClipboardBackend struct {
+	tool linuxClipboardTool
+}
+
+func NewLinuxClipboardBackend() (*LinuxClipboardBackend, error) {
+	if os.Getenv("WAYLAND_DISPLAY") != "" && hasCommand("wl-paste") && hasCommand("wl-copy") {
+		return &LinuxClipboardBackend{tool: toolWayland}, nil
+	}
+	if os.Getenv("DISPLAY") != "" {
+		if hasCommand("xclip") {
+			return &LinuxClipboardBackend{tool: toolXclip}, nil
+		}
+		if hasCommand("xsel") {
+			return &LinuxClipboardBackend{tool: toolXsel}, nil
+		}
+	}
+	return nil, errors.New("no clipboard tool found: install wl-clipboard (Wayland) or xclip/xsel (X11)")
+}
+
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+func (lb *LinuxClipboardBackend) Name() string {
+	switch lb.tool {
+	case toolWayland:
+		return "wayland"
+	case toolXclip:
+		return "x11-xclip"
+	default:
+		return "x11-xsel"
+	}
+}
+
+func (lb *LinuxClipboardBackend) Formats() ([]string, error) {
+	switch lb.tool {
+	case toolWayland:
+		out, err := runClipboardCommand(exec.Command("wl-paste", "--list-types"), nil)
+		if err != nil {
+			// wl-paste fails when the clipboard is empty
+			return nil, nil
+		}
+		return splitFormatLines(out), nil
+	case toolXclip:
+		out, err := runClipboardCommand(exec.Command("xclip", "-selection", "clipboard", "-t", "TARGETS", "-o"), nil)
+		if err != nil {
+			return nil, nil
+		}
+		return splitFormatLines(out), nil
+	default:
+		// xsel cannot enumerate targets; report text when there is any
+		if txt, err := lb.ReadText(); err == nil && txt != "" {
+			return []string{"text/plain;charset=utf-8"}, nil
+		}
+		return nil, nil
+	}
+}
+
+func (lb *LinuxClipboardBackend) ReadText() (string, error) {
+	var cmd *exec.Cmd
+	switch lb.tool {
+	case toolWayland:
+		cmd = exec.Command("wl-paste", "--no-newline", "--type", "text")
+	case toolXclip:
+		cmd = exec.Command("xclip", "-selection", "clipboard", "-t", "UTF8_STRING", "-o")
+	default:
+		cmd = exec.Command("xsel", "--clipboard", "--output")
+	}
+	out, err := runClipboardCommand(cmd, nil)
+	if err != nil {
+		return "", err
+	}
+	return normalizeClipboardText(string(out)), nil
+}
+
+func (lb *LinuxClipboardBackend) WriteText(text string) error {
+	var cmd *exec.Cmd
+	switch lb.tool {
+	case toolWayland:
+		cmd = exec.Command("wl-copy", "--type", "text/plain;charset=utf-8")
+	case toolXclip:
+		cmd = exec.Command("xclip", "-selection", "clipboard", "-i")
+	default:
+		cmd = exec.Command("xsel", "--clipboard", "--input")
+	}
+	return writeClipboardCommand(cmd, strings.NewReader(text))
+}
+
+func (lb *LinuxClipboardBackend) ReadImage() ([]byte, error) {
+	if lb.tool == toolXsel {
+		return nil, ErrUnsupported
+	}
+	formats, err := lb.Formats()
+	if err != nil {
+		return nil, err
+	}
+	mime := preferredImageType(formats)
+	if mime == "" {
+		return nil, errors.New("no image in clipboard")
+	}
+
+	var cmd *exec.Cmd
+	if lb.tool == toolWayland {
+		cmd = exec.Command("wl-paste", "--type", mime)
+	} else {
+		cmd = exec.Command("xclip", "-selection", "clipboard", "-t", mime, "-o")
+	}
+	return runClipboardCommand(cmd, nil)
+}
+
+// WriteImage offers the image as PNG, converting other formats first.
+func (lb *LinuxClipboardBackend) WriteImage(data []byte) error {
+	data, err := toPNG(data)
+	if err != nil {
+		return err
+	}
+	var cmd *exec.Cmd
+	switch lb.tool {
+	case toolWayland:
+		cmd = exec.Command("wl-copy", "--type", "image/png")
+	case toolXclip:
+		cmd = exec.Command("xclip", "-selection", "clipboard", "-t", "image/png", "-i")
+	default:
+		return ErrUnsupported
+	}
+	return writeClipboardCommand(cmd, bytes.NewReader(data))
+}
+
+func (lb *LinuxClipboardBackend) Clear() error {
+	var cmd *exec.Cmd
+	switch lb.tool {
+	case toolWayland:
+		cmd = exec.Command("wl-copy", "--clear")
+	case toolXclip:
+		cmd = exec.Command("xclip", "-selection", "clipboard", "-i")
+	default:
+		cmd = exec.Command("xsel", "--clipboard", "--clear")
+	}
+	return writeClipboardCommand(cmd, strings.NewReader(""))
+}
+
+// ChangeToken is the time the owner took the X11 selection, which changes
+// with every copy. Wayland has nothing comparable; there the native watcher
+// only signals real changes anyway.
+func (lb *LinuxClipboardBackend) ChangeToken() (string, error) {
+	if lb.tool != toolXclip {
+		return "", ErrUnsupported
+	}
+	out, err := runClipboardCommand(exec.Command("xclip", "-selection", "clipboard", "-t", "TIMESTAMP", "-o"), nil)
+	if err != nil {
+		return "", ErrUnsupported
+	}
+	// Owners that claim the selection at CurrentTime report 0 forever
+	if len(bytes.Trim(out, "\x000 \n")) == 0 {
+		return "", ErrUnsupported
+	}
+	return string(out), nil
+}
+
+// Watch follows the clipboard with wl-paste --watch on Wayland and with
+// clipnotify, which waits for XFIXES selection events, on X11.
+func (lb *LinuxClipboardBackend) Watch() (ClipboardWatcher, error) {
+	if lb.tool == toolWayland {
+		// The command gets the new content on stdin; drain it so the source
+		// application does not see a broken pipe.
+		return watchLines(exec.Command("wl-paste", "--watch", "sh", "-c", "cat >/dev/null; echo"))
+	}
+	if !hasCommand("clipnotify") {
+		return nil, errors.New("clipnotify not found, install it to watch the X11 clipboard")
+	}
+	return watchExits(func() *exec.Cmd {
+		return exec.Command("clipnotify", "-s", "clipboard")
+	})
+}
+
+// preferredImageType favours PNG and falls back to the first offered image type.
+func preferredImageType(formats []string) string {
+	fallback := ""
+	for _, f := range formats {
+		if f == "image/png" {
+			return f
+		}
+		if fallback == "" && strings.HasPrefix(f, "image/") {
+			fallback = f
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+const macFormatsScript = `ObjC.import("AppKit");
+(ObjC.deepUnwrap($.NSPasteboard.generalPasteboard.types) || []).join("\n")`
+
+// The image scripts move raw bytes over stdin and stdout; the run handler
+// returns nothing so osascript prints no result after the data.
+const macReadImageScript = `ObjC.import("AppKit");
+function run() {
+	var pb = $.NSPasteboard.generalPasteboard;
+	var data = pb.dataForType("public.png");
+	if (data.isNil()) data = pb.dataForType("public.tiff");
+	if (!data.isNil()) $.NSFileHandle.fileHandleWithStandardOutput.writeData(data);
+}`
+
+const macWriteImageScript = `ObjC.import("AppKit");
+function run() {
+	var data = $.NSFileHandle.fileHandleWithStandardInput.readDataToEndOfFile;
+	var pb = $.NSPasteboard.generalPasteboard;
+	pb.clearContents;
+	if (!pb.setDataForType(data, "public.png")) throw new Error("pasteboard refused the image");
+}`
+
+const macChangeCountScript = `ObjC.import("AppKit");
+$.NSPasteboard.generalPasteboard.changeCount`
+
+// macWatchScript prints a line whenever the pasteboard changeCount moves.
+// Reading the counter is cheap, unlike reading the content, so one process
+// can check it often.
+const macWatchScript = `ObjC.import("AppKit");
+var pb = $.NSPasteboard.generalPasteboard, out = $.NSFileHandle.fileHandleWithStandardOutput, last = pb.changeCount;
+while (true) {
+	delay(0.25);
+	var n = pb.changeCount;
+	if (n !== last) {
+		last = n;
+		out.writeData($(n + "\n").dataUsingEncoding($.NSUTF8StringEncoding));
+	}
+}`
+
+type MacOSClipboardBackend struct{}
+
+func NewMacOSClipboardBackend() *MacOSClipboardBackend {
+	return &MacOSClipboardBackend{}
+}
+
+func (mb *MacOSClipboardBackend) Name() string {
+	return "macos"
+}
+
+func (mb *MacOSClipboardBackend) Formats() ([]string, error) {
+	out, err := runClipboardCommand(exec.Command("/usr/bin/osascript", "-l", "JavaScript", "-e", macFormatsScript), nil)
+	if err != nil {
+		return nil, err
+	}
+	return splitFormatLines(out), nil
+}
+
+// ChangeToken is the pasteboard changeCount, which every copy increments.
+func (mb *MacOSClipboardBackend) ChangeToken() (string, error) {
+	out, err := runClipboardCommand(exec.Command("/usr/bin/osascript", "-l", "JavaScript", "-e", macChangeCountScript), nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (mb *MacOSClipboardBackend) Watch() (ClipboardWatcher, error) {
+	return watchLines(exec.Command("/usr/bin/osascript", "-l", "JavaScript", "-e", macWatchScript))
+}
+
+// ReadImage streams the raw pasteboard bytes, PNG or TIFF, through stdout.
+// NormalizeImage converts them to the storage format.
+func (mb *MacOSClipboardBackend) ReadImage() ([]byte, error) {
+	out, err := runClipboardCommand(exec.Command("/usr/bin/osascript", "-l", "JavaScript", "-e", macReadImageScript), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, errors.New("no image in clipboard")
+	}
+	return out, nil
+}
+
+func (mb *MacOSClipboardBackend) ReadText() (string, error) {
+	cmd := mb.utf8Env(exec.Command("/usr/bin/pbpaste", "-Prefer", "txt"))
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	return normalizeClipboardText(out.String()), nil
+}
+
+func (mb *MacOSClipboardBackend) WriteText(text string) error {
+	cmd := mb.utf8Env(exec.Command("/usr/bin/pbcopy"))
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+// WriteImage pipes the PNG to the pasteboard through stdin.
+func (mb *MacOSClipboardBackend) WriteImage(data []byte) error {
+	data, err := toPNG(data)
+	if err != nil {
+		return err
+	}
+	_, err = runClipboardCommand(exec.Command("/usr/bin/osascript", "-l", "JavaScript", "-e", macWriteImageScript), bytes.NewReader(data))
+	return err
+}
+
+func (mb *MacOSClipboardBackend) Clear() error {
+	return exec.Command("/usr/bin/pbcopy").Run()
+}
+
+func (mb *MacOSClipboardBackend) utf8Env(cmd *exec.Cmd) *exec.Cmd {
+	cmd.Env = append(cmd.Env,
+		"LANG=zh_TW.UTF-8",
+		"LC_ALL=zh_TW.UTF-8",
+		"LC_CTYPE=zh_TW.UTF-8",
+	)
+	return cmd
+}
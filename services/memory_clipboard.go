@@ -0,0 +1,304 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MimeText = "text/plain;charset=utf-8"
+	MimePNG  = "image/png"
+)
+
+// maxClipboardOps bounds the access log, so long headless runs do not grow
+// it without end.
+const maxClipboardOps = 1000
+
+// ClipboardPayload is one format offered by the clipboard.
+type ClipboardPayload struct {
+	Format string
+	Data   []byte
+}
+
+// ClipboardOp records a single access to the in-memory clipboard.
+type ClipboardOp struct {
+	Time   time.Time
+	Kind   string // read, write, formats, token or clear
+	Format string
+	Size   int
+}
+
+// MemoryClipboardBackend keeps the clipboard in process memory. It is used
+// for headless runs and tests, and can replay a script of clipboard changes.
+type MemoryClipboardBackend struct {
+	mu       sync.Mutex
+	payloads []ClipboardPayload
+	ops      []ClipboardOp
+	watchers []*memoryWatcher
+	seq      uint64
+}
+
+func NewMemoryClipboardBackend() *MemoryClipboardBackend {
+	return &MemoryClipboardBackend{}
+}
+
+func (mb *MemoryClipboardBackend) Name() string {
+	return "memory"
+}
+
+func (mb *MemoryClipboardBackend) Formats() ([]string, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	formats := make([]string, len(mb.payloads))
+	for i, p := range mb.payloads {
+		formats[i] = p.Format
+	}
+	mb.record("formats", "", 0)
+	return formats, nil
+}
+
+func (mb *MemoryClipboardBackend) ReadText() (string, error) {
+	data, _ := mb.ReadFormat(MimeText)
+	return normalizeClipboardText(string(data)), nil
+}
+
+func (mb *MemoryClipboardBackend) WriteText(text string) error {
+	mb.Set(ClipboardPayload{Format: MimeText, Data: []byte(text)})
+	return nil
+}
+
+func (mb *MemoryClipboardBackend) ReadImage() ([]byte, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	for _, p := range mb.payloads {
+		if isImageFormat(p.Format) {
+			mb.record("read", p.Format, len(p.Data))
+			return append([]byte(nil), p.Data...), nil
+		}
+	}
+	mb.record("read", MimePNG, 0)
+	return nil, errors.New("no image in clipboard")
+}
+
+func (mb *MemoryClipboardBackend) WriteImage(data []byte) error {
+	mb.Set(ClipboardPayload{Format: MimePNG, Data: data})
+	return nil
+}
+
+func (mb *MemoryClipboardBackend) Clear() error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.payloads = nil
+	mb.seq++
+	mb.record("clear", "", 0)
+	mb.notifyWatchers()
+	return nil
+}
+
+// ReadFormat returns the payload stored for an arbitrary format.
+func (mb *MemoryClipboardBackend) ReadFormat(format string) ([]byte, bool) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	for _, p := range mb.payloads {
+		if p.Format == format {
+			mb.record("read", format, len(p.Data))
+			return append([]byte(nil), p.Data...), true
+		}
+	}
+	mb.record("read", format, 0)
+	return nil, false
+}
+
+// Set replaces the clipboard content with the given payloads, like a copy in
+// another application would.
+func (mb *MemoryClipboardBackend) Set(payloads ...ClipboardPayload) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.payloads = make([]ClipboardPayload, len(payloads))
+	for i, p := range payloads {
+		mb.payloads[i] = ClipboardPayload{Format: p.Format, Data: append([]byte(nil), p.Data...)}
+		mb.record("write", p.Format, len(p.Data))
+	}
+	mb.seq++
+	mb.notifyWatchers()
+}
+
+// ChangeToken is a sequence number bumped by every Set and Clear. Reading it
+// is recorded as a "token" op.
+func (mb *MemoryClipboardBackend) ChangeToken() (string, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.record("token", "", 0)
+	return strconv.FormatUint(mb.seq, 10), nil
+}
+
+// Watch signals every Set and Clear.
+func (mb *MemoryClipboardBackend) Watch() (ClipboardWatcher, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	w := &memoryWatcher{backend: mb, changes: make(chan struct{}, 1)}
+	mb.watchers = append(mb.watchers, w)
+	return w, nil
+}
+
+func (mb *MemoryClipboardBackend) notifyWatchers() {
+	for _, w := range mb.watchers {
+		notify(w.changes)
+	}
+}
+
+type memoryWatcher struct {
+	backend *MemoryClipboardBackend
+	changes chan struct{}
+}
+
+func (w *memoryWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+func (w *memoryWatcher) Close() error {
+	mb := w.backend
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for i, other := range mb.watchers {
+		if other == w {
+			mb.watchers = append(mb.watchers[:i], mb.watchers[i+1:]...)
+			close(w.changes)
+			break
+		}
+	}
+	return nil
+}
+
+// Ops returns a copy of the accesses recorded since the last ResetOps, the
+// most recent maxClipboardOps of them.
+func (mb *MemoryClipboardBackend) Ops() []ClipboardOp {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	ops := mb.ops
+	if len(ops) > maxClipboardOps {
+		ops = ops[len(ops)-maxClipboardOps:]
+	}
+	return append([]ClipboardOp(nil), ops...)
+}
+
+func (mb *MemoryClipboardBackend) ResetOps() {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.ops = nil
+}
+
+func (mb *MemoryClipboardBackend) record(kind, format string, size int) {
+	mb.ops = append(mb.ops, ClipboardOp{Time: time.Now(), Kind: kind, Format: format, Size: size})
+	// Drop the oldest in batches rather than on every access
+	if len(mb.ops) >= 2*maxClipboardOps {
+		mb.ops = append([]ClipboardOp(nil), mb.ops[len(mb.ops)-maxClipboardOps:]...)
+	}
+}
+
+// ScriptStep changes the clipboard After the previous step has been applied.
+type ScriptStep struct {
+	After     time.Duration
+	Text      string
+	ImagePath string
+	Format    string
+	Data      string
+}
+
+// Play applies the steps on a background goroutine. Closing stop aborts the
+// remaining steps. The returned channel carries an error for every step
+// that could not be applied and is closed once playback ends; it is
+// buffered, so it need not be read.
+func (mb *MemoryClipboardBackend) Play(steps []ScriptStep, stop <-chan struct{}) <-chan error {
+	errs := make(chan error, len(steps))
+	go func() {
+		defer close(errs)
+		for i, step := range steps {
+			select {
+			case <-time.After(step.After):
+			case <-stop:
+				return
+			}
+			payloads, err := step.payloads()
+			if err != nil {
+				errs <- fmt.Errorf("clipboard script step %d: %w", i+1, err)
+				continue
+			}
+			mb.Set(payloads...)
+		}
+	}()
+	return errs
+}
+
+func (s ScriptStep) payloads() ([]ClipboardPayload, error) {
+	var payloads []ClipboardPayload
+	if s.Text != "" {
+		payloads = append(payloads, ClipboardPayload{Format: MimeText, Data: []byte(s.Text)})
+	}
+	if s.ImagePath != "" {
+		data, err := os.ReadFile(s.ImagePath)
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, ClipboardPayload{Format: MimePNG, Data: data})
+	}
+	if s.Format != "" {
+		payloads = append(payloads, ClipboardPayload{Format: s.Format, Data: []byte(s.Data)})
+	}
+	return payloads, nil
+}
+
+// LoadClipboardScript reads a JSON array of steps such as
+// [{"after": "2s", "text": "hello"}, {"after": "500ms", "image": "shot.png"}].
+func LoadClipboardScript(path string) ([]ScriptStep, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []struct {
+		After  string `json:"after"`
+		Text   string `json:"text"`
+		Image  string `json:"image"`
+		Format string `json:"format"`
+		Data   string `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("parse clipboard script: %w", err)
+	}
+
+	steps := make([]ScriptStep, 0, len(entries))
+	for i, e := range entries {
+		var after time.Duration
+		if strings.TrimSpace(e.After) != "" {
+			if after, err = time.ParseDuration(e.After); err != nil {
+				return nil, fmt.Errorf("clipboard script step %d: %w", i+1, err)
+			}
+		}
+		// A missing image would otherwise only show up when its step plays
+		if e.Image != "" {
+			if _, err := os.Stat(e.Image); err != nil {
+				return nil, fmt.Errorf("clipboard script step %d: %w", i+1, err)
+			}
+		}
+		steps = append(steps, ScriptStep{
+			After:     after,
+			Text:      e.Text,
+			ImagePath: e.Image,
+			Format:    e.Format,
+			Data:      e.Data,
+		})
+	}
+	return steps, nil
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryClipboardOpsAreBounded(t *testing.T) {
+	mb := NewMemoryClipboardBackend()
+	for i := 0; i < 5*maxClipboardOps; i++ {
+		mb.ReadText()
+	}
+	mb.WriteText("last")
+
+	ops := mb.Ops()
+	if len(ops) != maxClipboardOps {
+		t.Fatalf("got %d ops, want the last %d", len(ops), maxClipboardOps)
+	}
+	if last := ops[len(ops)-1]; last.Kind != "write" {
+		t.Fatalf("last op is %q, want the write", last.Kind)
+	}
+	if n := len(mb.ops); n >= 2*maxClipboardOps {
+		t.Fatalf("log holds %d ops", n)
+	}
+}
+
+func TestMemoryClipboardPlayReportsFailedSteps(t *testing.T) {
+	mb := NewMemoryClipboardBackend()
+	steps := []ScriptStep{
+		{Text: "one"},
+		{ImagePath: filepath.Join(t.TempDir(), "missing.png")},
+		{Text: "two"},
+	}
+	var errs []error
+	for err := range mb.Play(steps, nil) {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("got errors %v, want one for step 2", errs)
+	}
+	if text, _ := mb.ReadText(); text != "two" {
+		t.Fatalf("clipboard holds %q after playback, want the last step", text)
+	}
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+
+	"golang.org/x/image/draw"
+
+	"clipmini/models"
+)
+
+// PerceptualHash returns the 64-bit difference hash (dHash) of an image as
+// hex. The image is shrunk to 9×8 grey pixels and each bit says whether a
+// pixel is brighter than its right neighbour, so re-encoding, scaling or
+// nudging a selection by a pixel or two changes few bits.
+func PerceptualHash(data []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	return perceptualHash(img), nil
+}
+
+func perceptualHash(img image.Image) string {
+	// A filtering kernel averages the whole image; sampling would alias
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.CatmullRom.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", hash)
+}
+
+// HashDistance counts the bits two perceptual hashes differ in, or returns
+// -1 when either is missing or malformed.
+func HashDistance(a, b string) int {
+	x, errA := strconv.ParseUint(a, 16, 64)
+	y, errB := strconv.ParseUint(b, 16, 64)
+	if errA != nil || errB != nil {
+		return -1
+	}
+	return bits.OnesCount64(x ^ y)
+}
+
+// NearDuplicate reports whether a and b look alike under config.
+func NearDuplicate(a, b *models.ClipboardItem, config models.ImageConfig) bool {
+	if a.Type != models.ClipImage || b.Type != models.ClipImage {
+		return false
+	}
+	d := HashDistance(a.PHash, b.PHash)
+	if d < 0 || d > config.NearDuplicateDistance {
+		return false
+	}
+	window := config.NearDuplicateWindowDuration()
+	return window == 0 || a.Timestamp.Sub(b.Timestamp).Abs() <= window
+}
+
+// FindNearDuplicate returns the newest item in items that item is a
+// near-duplicate of, if any.
+func FindNearDuplicate(items []*models.ClipboardItem, item *models.ClipboardItem, config models.ImageConfig) *models.ClipboardItem {
+	var found *models.ClipboardItem
+	for _, other := range items {
+		if other.ID != item.ID && NearDuplicate(item, other, config) &&
+			(found == nil || other.Timestamp.After(found.Timestamp)) {
+			found = other
+		}
+	}
+	return found
+}
+
+// GroupNearDuplicates maps the ID of every image item that has near-duplicates
+// to its group, in the order of items, which is newest first. An item joins a
+// group when it is a near-duplicate of the group's oldest member so far, so a
+// series of small nudges forms one group even if its ends differ more.
+func GroupNearDuplicates(items []*models.ClipboardItem, config models.ImageConfig) map[string][]*models.ClipboardItem {
+	var groups [][]*models.ClipboardItem
+	for _, item := range items {
+		if item.Type != models.ClipImage || item.PHash == "" {
+			continue
+		}
+		joined := false
+		for i, group := range groups {
+			if NearDuplicate(group[len(group)-1], item, config) {
+				groups[i] = append(group, item)
+				joined = true
+				break
+			}
+		}
+		if !joined {
+			groups = append(groups, []*models.ClipboardItem{item})
+		}
+	}
+
+	byID := make(map[string][]*models.ClipboardItem)
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		for _, item := range group {
+			byID[item.ID] = group
+		}
+	}
+	return byID
+}
+
+// BestOfGroup picks the capture to keep: the most pixels as captured, then
+// the newest.
+func BestOfGroup(group []*models.ClipboardItem) *models.ClipboardItem {
+	pixels := func(item *models.ClipboardItem) int {
+		if item.Original == nil {
+			return 0
+		}
+		return item.Original.Width * item.Original.Height
+	}
+	var best *models.ClipboardItem
+	for _, item := range group {
+		if best == nil || pixels(item) > pixels(best) ||
+			pixels(item) == pixels(best) && item.Timestamp.After(best.Timestamp) {
+			best = item
+		}
+	}
+	return best
+}
@@ -0,0 +1,325 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"clipmini/models"
+)
+
+// SecretFinding is a detected secret; Start and End are byte offsets.
+type SecretFinding struct {
+	Rule       string
+	Start, End int
+	Action     models.PrivacyAction
+}
+
+// PrivacyDecision is what the capture pipeline should do with a clip.
+type PrivacyDecision struct {
+	Action    models.PrivacyAction
+	Text      string // masked text when Action is PrivacyMask
+	ExpiresAt time.Time
+	Findings  []SecretFinding
+}
+
+type privacyRule struct {
+	name   string
+	re     *regexp.Regexp
+	action models.PrivacyAction
+	check  func(match string) bool
+}
+
+var knownKeyRules = []struct {
+	name    string
+	pattern string
+}{
+	{"aws-access-key", `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`},
+	{"github-token", `\bgh[pousr]_[A-Za-z0-9]{36,255}\b`},
+	{"github-pat", `\bgithub_pat_[A-Za-z0-9_]{22,255}\b`},
+	{"slack-token", `\bxox[abprs]-[A-Za-z0-9-]{10,}\b`},
+	{"jwt", `\beyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{5,}`},
+	{"private-key", `-----BEGIN [A-Z ]*PRIVATE KEY-----`},
+}
+
+var cardCandidate = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+
+// PrivacyFilter detects secrets in copied text according to the privacy rules
+// in AppConfig.
+type PrivacyFilter struct {
+	config models.PrivacyConfig
+	rules  []privacyRule
+}
+
+func NewPrivacyFilter(config models.PrivacyConfig) (*PrivacyFilter, error) {
+	pf := &PrivacyFilter{config: config}
+	if config.DefaultAction == "" {
+		pf.config.DefaultAction = models.PrivacySkip
+	}
+
+	if config.DetectKnownKeys {
+		for _, r := range knownKeyRules {
+			pf.rules = append(pf.rules, privacyRule{name: r.name, re: regexp.MustCompile(r.pattern)})
+		}
+	}
+	if config.DetectCards {
+		pf.rules = append(pf.rules, privacyRule{name: "credit-card", re: cardCandidate, check: luhnValid})
+	}
+	for _, r := range config.Rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("privacy rule %q: %w", r.Name, err)
+		}
+		pf.rules = append(pf.rules, privacyRule{name: r.Name, re: re, action: r.Action})
+	}
+	return pf, nil
+}
+
+// Scan returns every secret found in text.
+func (pf *PrivacyFilter) Scan(text string) []SecretFinding {
+	if !pf.config.Enabled {
+		return nil
+	}
+
+	var findings []SecretFinding
+	for _, r := range pf.rules {
+		action := r.action
+		if action == "" {
+			action = pf.config.DefaultAction
+		}
+		for _, loc := range r.re.FindAllStringIndex(text, -1) {
+			if r.check != nil && !r.check(text[loc[0]:loc[1]]) {
+				continue
+			}
+			findings = append(findings, SecretFinding{Rule: r.name, Start: loc[0], End: loc[1], Action: action})
+		}
+	}
+	if pf.config.DetectEntropy {
+		findings = append(findings, pf.scanEntropy(text)...)
+	}
+	sort.Slice(findings, func(i, j int) bool { return findings[i].Start < findings[j].Start })
+	return findings
+}
+
+// Inspect decides what to do with a clip: the strictest action of all
+// findings wins.
+func (pf *PrivacyFilter) Inspect(text string, now time.Time) PrivacyDecision {
+	decision := PrivacyDecision{Action: models.PrivacyAllow, Text: text}
+	decision.Findings = pf.Scan(text)
+	for _, f := range decision.Findings {
+		if f.Action.Severity() > decision.Action.Severity() {
+			decision.Action = f.Action
+		}
+	}
+
+	switch decision.Action {
+	case models.PrivacyMask:
+		// Text matched by an allow rule stays as it is
+		var masked []SecretFinding
+		for _, f := range decision.Findings {
+			if f.Action != models.PrivacyAllow {
+				masked = append(masked, f)
+			}
+		}
+		decision.Text = maskFindings(text, masked)
+	case models.PrivacyExpire:
+		decision.ExpiresAt = now.Add(time.Duration(pf.config.ExpireAfterSeconds) * time.Second)
+	}
+	return decision
+}
+
+// MarkerAction returns the strictest policy among the concealed/transient
+// markers offered in formats, and the marker that triggered it.
+func (pf *PrivacyFilter) MarkerAction(formats []string) (models.PrivacyAction, string) {
+	action, marker := models.PrivacyAllow, ""
+	if !pf.config.Enabled {
+		return action, marker
+	}
+	for _, f := range formats {
+		if a, ok := pf.config.MarkerPolicies[f]; ok && a.Severity() > action.Severity() {
+			action, marker = a, f
+		}
+	}
+	return action, marker
+}
+
+// ApplyMarker tightens a content decision with the action of a clipboard
+// marker. A masked marker hides the whole text since the secret cannot be
+// located.
+func (pf *PrivacyFilter) ApplyMarker(d PrivacyDecision, marker models.PrivacyAction, now time.Time) PrivacyDecision {
+	if marker.Severity() <= d.Action.Severity() {
+		return d
+	}
+	d.Action = marker
+	switch marker {
+	case models.PrivacyMask:
+		d.Text = "••••••••"
+	case models.PrivacyExpire:
+		d.ExpiresAt = now.Add(time.Duration(pf.config.ExpireAfterSeconds) * time.Second)
+	}
+	return d
+}
+
+// scanEntropy flags long whitespace-delimited tokens that mix character
+// classes and look random, the typical shape of generated passwords.
+func (pf *PrivacyFilter) scanEntropy(text string) []SecretFinding {
+	var findings []SecretFinding
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		token := text[start:end]
+		if looksLikeSecret(token, pf.config.EntropyMinLength, pf.config.EntropyThreshold) {
+			findings = append(findings, SecretFinding{Rule: "high-entropy", Start: start, End: end, Action: pf.config.DefaultAction})
+		}
+		start = -1
+	}
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			flush(i)
+		} else if start < 0 {
+			start = i
+		}
+	}
+	flush(len(text))
+	return findings
+}
+
+func looksLikeSecret(token string, minLength int, threshold float64) bool {
+	if len([]rune(token)) < minLength || looksLikeName(token) {
+		return false
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range token {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case r > unicode.MaxASCII:
+			return false // prose in other scripts, not a key
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, symbol} {
+		if has {
+			classes++
+		}
+	}
+	return classes >= 3 && shannonEntropy(token) >= threshold
+}
+
+var (
+	nameSegment = regexp.MustCompile(`^[A-Za-z0-9_~@+$-]*$`)
+	letterWord  = regexp.MustCompile(`[A-Z]?[a-z]+|[A-Z]+`)
+)
+
+// looksLikeName reports whether token is a URL, a file or module path, a
+// dotted identifier such as a bundle ID, or a camelCase/snake_case name.
+// They mix character classes like keys do but are no secret.
+func looksLikeName(token string) bool {
+	if strings.Contains(token, "://") || strings.HasPrefix(token, "www.") {
+		return true
+	}
+	segments := strings.FieldsFunc(token, func(r rune) bool {
+		return r == '/' || r == '\\' || r == '.' || r == ':'
+	})
+	for _, seg := range segments {
+		if !nameSegment.MatchString(seg) {
+			return false
+		}
+	}
+
+	if strings.ContainsAny(token, `/\`) {
+		rooted := strings.HasPrefix(token, "/") || strings.HasPrefix(token, "~") || strings.HasPrefix(token, ".") ||
+			len(token) > 2 && token[1] == ':' && (token[2] == '\\' || token[2] == '/')
+		first, _, _ := strings.Cut(token, "/")
+		if rooted || strings.Contains(first, ".") {
+			return true
+		}
+	}
+	return wordy(token)
+}
+
+// wordy reports whether the letters of s read as words rather than random
+// characters: random mixed case breaks them into runs of one or two letters.
+func wordy(s string) bool {
+	words := letterWord.FindAllString(s, -1)
+	if len(words) == 0 {
+		return false
+	}
+	letters := 0
+	for _, w := range words {
+		letters += len(w)
+	}
+	return letters >= 3*len(words)
+}
+
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && n <= 19 && sum%10 == 0
+}
+
+// maskFindings replaces every secret with bullets, keeping the last four
+// characters of long secrets so the user can still tell them apart.
+func maskFindings(text string, findings []SecretFinding) string {
+	var b strings.Builder
+	pos := 0
+	for _, f := range findings {
+		if f.Start < pos {
+			if f.End <= pos {
+				continue
+			}
+			f.Start = pos
+		}
+		b.WriteString(text[pos:f.Start])
+		secret := []rune(text[f.Start:f.End])
+		b.WriteString("••••••••")
+		if len(secret) >= 12 {
+			b.WriteString(string(secret[len(secret)-4:]))
+		}
+		pos = f.End
+	}
+	b.WriteString(text[pos:])
+	return b.String()
+}
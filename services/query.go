@@ -0,0 +1,365 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"clipmini/models"
+)
+
+// Query is a parsed history query. Terms are separated by spaces and must
+// all match:
+//
+//	word         fuzzy match (fzf style)
+//	'text "a b"  case-insensitive substring
+//	w:word       whole word
+//	/re/ /re/i   regular expression; /usr/bin, with no closing slash, is text
+//	type:image   clip type (text or image)
+//	before:2026-10-01 after:2026-10-01
+//	len>500 len<=20 len:10
+//	is:pinned
+//	tag:sql in:"deploy commands"
+//
+// Any term can be negated with a leading "-".
+type Query struct {
+	Raw   string
+	terms []queryTerm
+}
+
+type termKind int
+
+const (
+	termFuzzy termKind = iota
+	termExact
+	termWord
+	termRegex
+	termType
+	termBefore
+	termAfter
+	termLen
+	termPinned
+	termTag
+	termCollection
+)
+
+type queryTerm struct {
+	kind   termKind
+	negate bool
+	text   string
+	re     *regexp.Regexp
+	clip   models.ClipType
+	at     time.Time
+	op     string
+	n      int
+}
+
+// Span is a highlighted range of rune offsets, End exclusive.
+type Span struct {
+	Start, End int
+}
+
+var (
+	lenFilter   = regexp.MustCompile(`^len(<=|>=|<|>|=|:)(\d+)$`)
+	regexFilter = regexp.MustCompile(`^/(.+)/(i?)$`)
+)
+
+// ParseQuery parses a query string. Dates are interpreted in loc.
+func ParseQuery(s string, loc *time.Location) (*Query, error) {
+	q := &Query{Raw: s}
+	for _, field := range splitQueryFields(s) {
+		term, err := parseQueryTerm(field, loc)
+		if err != nil {
+			return nil, err
+		}
+		q.terms = append(q.terms, term)
+	}
+	return q, nil
+}
+
+func parseQueryTerm(field string, loc *time.Location) (queryTerm, error) {
+	var t queryTerm
+	if len(field) > 1 && field[0] == '-' {
+		t.negate = true
+		field = field[1:]
+	}
+
+	lower := strings.ToLower(field)
+	switch {
+	case regexFilter.MatchString(field):
+		m := regexFilter.FindStringSubmatch(field)
+		pattern := m[1]
+		if m[2] == "i" {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return t, fmt.Errorf("bad regex %q: %w", field, err)
+		}
+		t.kind, t.re = termRegex, re
+	case strings.HasPrefix(field, "/"):
+		// A path rather than a regex
+		t.kind, t.text = termExact, lower
+	case strings.HasPrefix(field, `"`):
+		t.kind, t.text = termExact, strings.ToLower(strings.Trim(field, `"`))
+	case strings.HasPrefix(field, "'") && len(field) > 1:
+		t.kind, t.text = termExact, strings.ToLower(field[1:])
+	case strings.HasPrefix(lower, "w:"):
+		t.kind, t.text = termWord, lower[2:]
+	case lower == "is:pinned":
+		t.kind = termPinned
+	case strings.HasPrefix(lower, "tag:"):
+		t.kind, t.text = termTag, strings.Trim(field[4:], `"`)
+	case strings.HasPrefix(lower, "in:"):
+		t.kind, t.text = termCollection, strings.Trim(field[3:], `"`)
+	case strings.HasPrefix(lower, "type:"):
+		typ, err := models.ParseClipType(strings.ToUpper(lower[5:]))
+		if err != nil {
+			return t, err
+		}
+		t.kind, t.clip = termType, typ
+	case strings.HasPrefix(lower, "before:"), strings.HasPrefix(lower, "after:"):
+		name, value, _ := strings.Cut(field, ":")
+		at, err := parseQueryDate(value, loc)
+		if err != nil {
+			return t, err
+		}
+		t.kind, t.at = termAfter, at
+		if strings.EqualFold(name, "before") {
+			t.kind = termBefore
+		}
+	case lenFilter.MatchString(lower):
+		m := lenFilter.FindStringSubmatch(lower)
+		n, _ := strconv.Atoi(m[2])
+		t.kind, t.op, t.n = termLen, m[1], n
+	default:
+		t.kind, t.text = termFuzzy, field
+	}
+	return t, nil
+}
+
+func parseQueryDate(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02", "2006/01/02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad date %q", s)
+}
+
+// splitQueryFields splits on spaces, keeping "quoted phrases", in:"quoted
+// values" and /regexes/ with spaces together. A slash that is never closed
+// starts a plain word, such as a path.
+func splitQueryFields(s string) []string {
+	var fields []string
+	var cur strings.Builder
+	var closer rune
+	for _, r := range s {
+		switch {
+		case closer != 0:
+			cur.WriteRune(r)
+			if r == closer {
+				closer = 0
+			}
+		case r == ' ' || r == '\t' || r == '\n':
+			if cur.Len() > 0 {
+				fields = append(fields, cur.String())
+				cur.Reset()
+			}
+		default:
+			prefix := strings.TrimPrefix(cur.String(), "-")
+			if r == '"' || (prefix == "" && r == '/') {
+				closer = r
+			}
+			cur.WriteRune(r)
+		}
+	}
+	if closer == '/' {
+		rest := cur.String()
+		if i := strings.IndexAny(rest, " \t\n"); i >= 0 {
+			return append(append(fields, rest[:i]), splitQueryFields(rest[i:])...)
+		}
+	}
+	if cur.Len() > 0 {
+		fields = append(fields, cur.String())
+	}
+	return fields
+}
+
+// IsEmpty reports whether the query has no terms and matches everything.
+func (q *Query) IsEmpty() bool {
+	return len(q.terms) == 0
+}
+
+// Match reports whether the item satisfies every term, and its fuzzy score.
+func (q *Query) Match(item *models.ClipboardItem) (int, bool) {
+	score := 0
+	for _, t := range q.terms {
+		s, ok := t.match(item)
+		if ok == t.negate {
+			return 0, false
+		}
+		if !t.negate {
+			score += s
+		}
+	}
+	return score, true
+}
+
+func (t queryTerm) match(item *models.ClipboardItem) (int, bool) {
+	switch t.kind {
+	case termType:
+		return 0, item.Type == t.clip
+	case termPinned:
+		return 0, item.Pinned
+	case termTag:
+		return 0, item.HasTag(t.text)
+	case termCollection:
+		return 0, strings.EqualFold(item.Collection, t.text)
+	case termBefore:
+		return 0, item.Timestamp.Before(t.at)
+	case termAfter:
+		return 0, !item.Timestamp.Before(t.at)
+	case termLen:
+		return 0, compareLen(utf8.RuneCountInString(item.Content), t.op, t.n)
+	case termExact:
+		return 0, strings.Contains(strings.ToLower(item.Content), t.text)
+	case termWord:
+		for _, term := range Tokenize(item.Content) {
+			if term == t.text {
+				return 0, true
+			}
+		}
+		return 0, false
+	case termRegex:
+		return 0, t.re.MatchString(item.Content)
+	default:
+		score, _, ok := FuzzyMatch(item.Content, t.text)
+		return score, ok
+	}
+}
+
+func compareLen(n int, op string, want int) bool {
+	switch op {
+	case "<":
+		return n < want
+	case "<=":
+		return n <= want
+	case ">":
+		return n > want
+	case ">=":
+		return n >= want
+	default:
+		return n == want
+	}
+}
+
+// Filter returns the matching items, best fuzzy score first and newest
+// first among equal scores.
+func (q *Query) Filter(items []*models.ClipboardItem) []*models.ClipboardItem {
+	type scored struct {
+		item  *models.ClipboardItem
+		score int
+	}
+	var matches []scored
+	for _, item := range items {
+		if score, ok := q.Match(item); ok {
+			matches = append(matches, scored{item, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].item.Timestamp.After(matches[j].item.Timestamp)
+	})
+
+	out := make([]*models.ClipboardItem, len(matches))
+	for i, m := range matches {
+		out[i] = m.item
+	}
+	return out
+}
+
+// indexTokens returns the literal tokens the search index can use to narrow
+// down candidates before every term is evaluated.
+func (q *Query) indexTokens() (substring, wholeWord []string) {
+	for _, t := range q.terms {
+		if t.negate {
+			continue
+		}
+		switch t.kind {
+		case termExact:
+			substring = append(substring, t.text)
+		case termWord:
+			wholeWord = append(wholeWord, t.text)
+		}
+	}
+	return substring, wholeWord
+}
+
+// Highlight returns the spans of text matched by the positive text terms.
+func (q *Query) Highlight(text string) []Span {
+	var spans []Span
+	lower := strings.ToLower(text)
+	for _, t := range q.terms {
+		if t.negate {
+			continue
+		}
+		switch t.kind {
+		case termFuzzy:
+			if _, positions, ok := FuzzyMatch(text, t.text); ok {
+				for _, p := range positions {
+					spans = append(spans, Span{p, p + 1})
+				}
+			}
+		case termExact, termWord:
+			if t.text == "" {
+				continue
+			}
+			for off := 0; ; {
+				i := strings.Index(lower[off:], t.text)
+				if i < 0 {
+					break
+				}
+				start := off + i
+				spans = append(spans, byteSpanToRunes(lower, start, start+len(t.text)))
+				off = start + len(t.text)
+			}
+		case termRegex:
+			for _, loc := range t.re.FindAllStringIndex(text, -1) {
+				if loc[1] > loc[0] {
+					spans = append(spans, byteSpanToRunes(text, loc[0], loc[1]))
+				}
+			}
+		}
+	}
+	return mergeSpans(spans)
+}
+
+func byteSpanToRunes(s string, start, end int) Span {
+	return Span{utf8.RuneCountInString(s[:start]), utf8.RuneCountInString(s[:end])}
+}
+
+func mergeSpans(spans []Span) []Span {
+	if len(spans) == 0 {
+		return nil
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	merged := []Span{spans[0]}
+	for _, sp := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if sp.Start <= last.End {
+			if sp.End > last.End {
+				last.End = sp.End
+			}
+			continue
+		}
+		merged = append(merged, sp)
+	}
+	return merged
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"clipmini/models"
+)
+
+func TestSplitQueryFields(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"  foo   bar ", []string{"foo", "bar"}},
+		{`"deploy to prod" x`, []string{`"deploy to prod"`, "x"}},
+		{`in:"deploy commands" tag:sql`, []string{`in:"deploy commands"`, "tag:sql"}},
+		{"/foo bar/i baz", []string{"/foo bar/i", "baz"}},
+		{"-/a b/ c", []string{"-/a b/", "c"}},
+		{"/usr/bin go", []string{"/usr/bin", "go"}},
+		{"/tmp foo", []string{"/tmp", "foo"}},
+		{`/tmp "a b" c`, []string{"/tmp", `"a b"`, "c"}},
+		{`"unterminated phrase`, []string{`"unterminated phrase`}},
+	}
+	for _, tt := range tests {
+		if got := splitQueryFields(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitQueryFields(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryTerms(t *testing.T) {
+	loc := time.UTC
+	day := time.Date(2026, 10, 1, 0, 0, 0, 0, loc)
+	tests := []struct {
+		in   string
+		want queryTerm
+	}{
+		{"foo", queryTerm{kind: termFuzzy, text: "foo"}},
+		{"-foo", queryTerm{kind: termFuzzy, negate: true, text: "foo"}},
+		{"'Exact", queryTerm{kind: termExact, text: "exact"}},
+		{`"Two Words"`, queryTerm{kind: termExact, text: "two words"}},
+		{"w:Word", queryTerm{kind: termWord, text: "word"}},
+		{"/usr/bin", queryTerm{kind: termExact, text: "/usr/bin"}},
+		{"/tmp", queryTerm{kind: termExact, text: "/tmp"}},
+		{"type:image", queryTerm{kind: termType, clip: models.ClipImage}},
+		{"before:2026-10-01", queryTerm{kind: termBefore, at: day}},
+		{"after:2026/10/01", queryTerm{kind: termAfter, at: day}},
+		{"-after:2026-10-01T00:00", queryTerm{kind: termAfter, negate: true, at: day}},
+		{"len>=20", queryTerm{kind: termLen, op: ">=", n: 20}},
+		{"is:pinned", queryTerm{kind: termPinned}},
+		{"tag:sql", queryTerm{kind: termTag, text: "sql"}},
+		{`in:"deploy commands"`, queryTerm{kind: termCollection, text: "deploy commands"}},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.in, loc)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.in, err)
+			continue
+		}
+		if len(q.terms) != 1 || !reflect.DeepEqual(q.terms[0], tt.want) {
+			t.Errorf("ParseQuery(%q) = %+v, want %+v", tt.in, q.terms, tt.want)
+		}
+	}
+}
+
+func TestParseQueryRegex(t *testing.T) {
+	tests := []struct {
+		in, text string
+		match    bool
+	}{
+		{"/err(or)?s/", "3 errors", true},
+		{"/ERROR/", "error", false},
+		{"/ERROR/i", "error", true},
+		{"/a b/", "a b", true},
+		{"/usr/bin/", "/usr/bin/go", true},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.in, time.UTC)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.in, err)
+			continue
+		}
+		if q.terms[0].kind != termRegex {
+			t.Errorf("ParseQuery(%q) is not a regex", tt.in)
+			continue
+		}
+		if _, ok := q.Match(models.NewTextItem(tt.text)); ok != tt.match {
+			t.Errorf("%s matching %q = %v, want %v", tt.in, tt.text, ok, tt.match)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, in := range []string{
+		"/(/",
+		"before:yesterday",
+		"after:2026-13-01",
+		"type:video",
+	} {
+		if _, err := ParseQuery(in, time.UTC); err == nil {
+			t.Errorf("ParseQuery(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestQueryMatchesPaths(t *testing.T) {
+	q, err := ParseQuery("/usr/bin -'local", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for text, want := range map[string]bool{
+		"export PATH=/usr/bin:$PATH": true,
+		"/usr/local/bin":             false,
+		"/usr/bin/local":             false,
+		"usr bin":                    false,
+	} {
+		if _, ok := q.Match(models.NewTextItem(text)); ok != want {
+			t.Errorf("match %q = %v, want %v", text, ok, want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"clipmini/utils"
+)
+
+// rekeySuffix marks the files a rekey writes next to the ones they replace.
+// Key info staged this way means the rekey has not committed yet.
+const rekeySuffix = ".rekey"
+
+// StageKeyInfo writes new key info next to the current one. Until
+// CommitKeyInfo renames it into place, the old key stays the valid one.
+func (fs *FileService) StageKeyInfo(ki *KeyInfo) error {
+	return SaveKeyInfo(fs.config.KeyInfoPath+rekeySuffix, ki)
+}
+
+func (fs *FileService) CommitKeyInfo() error {
+	return os.Rename(fs.config.KeyInfoPath+rekeySuffix, fs.config.KeyInfoPath)
+}
+
+// StageImage writes data sealed with vault for the image at path and returns
+// where it goes once committed. Blobs are renamed, as their names are keyed
+// too; see Vault.BlobName.
+func (fs *FileService) StageImage(path string, data []byte, vault *Vault) (string, error) {
+	staged := path
+	if fs.IsBlobPath(path) {
+		staged = fs.BlobPath(vault.BlobName(data), strings.TrimPrefix(filepath.Ext(path), "."))
+		if err := os.MkdirAll(filepath.Dir(staged), 0o755); err != nil {
+			return "", err
+		}
+	}
+	sealed, err := vault.Seal(data)
+	if err != nil {
+		return "", err
+	}
+	return staged, utils.WriteFileAtomic(staged+rekeySuffix, sealed, 0o600)
+}
+
+// StageRenames records the images that StageImage moved, keyed by their
+// old path, so the old files are deleted once the copies are swapped in,
+// even when that happens in RecoverRekey.
+func (fs *FileService) StageRenames(moved map[string]string) error {
+	var b strings.Builder
+	for old, path := range moved {
+		if old != path {
+			b.WriteString(old + "\n")
+		}
+	}
+	if err := os.MkdirAll(fs.imageDir, 0o755); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(fs.renamesPath(), []byte(b.String()), 0o600)
+}
+
+// CommitImages swaps the staged copies of the images in moved, which maps
+// old paths to the ones StageImage returned, and deletes the old files.
+func (fs *FileService) CommitImages(moved map[string]string) error {
+	for _, path := range moved {
+		if err := os.Rename(path+rekeySuffix, path); err != nil {
+			return err
+		}
+	}
+	return fs.removeRenamed()
+}
+
+// removeRenamed deletes the images listed by StageRenames.
+func (fs *FileService) removeRenamed() error {
+	data, err := os.ReadFile(fs.renamesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fs.CleanupImageFiles(strings.Fields(string(data)))
+	return os.Remove(fs.renamesPath())
+}
+
+func (fs *FileService) renamesPath() string {
+	return filepath.Join(fs.imageDir, ".renames"+rekeySuffix)
+}
+
+// RecoverRekey cleans up after a rekey that did not finish. Staged key info
+// means it stopped before committing, so the staged files are dropped and
+// the data stays readable with the old key. Otherwise the new key info is
+// current and the staged files, all written before the commit, are swapped
+// in and the images they replace deleted.
+func (fs *FileService) RecoverRekey() error {
+	pendingKeyInfo := fs.config.KeyInfoPath + rekeySuffix
+	_, err := os.Stat(pendingKeyInfo)
+	rollBack := err == nil
+
+	staged, err := fs.stagedFiles()
+	if err != nil {
+		return err
+	}
+	for _, path := range staged {
+		switch {
+		case rollBack:
+			err = os.Remove(path)
+		case path == fs.renamesPath():
+			continue // handled once the copies are in place
+		default:
+			err = os.Rename(path, strings.TrimSuffix(path, rekeySuffix))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if rollBack {
+		return os.Remove(pendingKeyInfo)
+	}
+	if len(staged) == 0 {
+		return nil
+	}
+	// Thumbnails left from before the commit are sealed with the old key
+	if err := fs.DeleteThumbnails(); err != nil {
+		return err
+	}
+	return fs.removeRenamed()
+}
+
+func (fs *FileService) stagedFiles() ([]string, error) {
+	var staged []string
+	for _, path := range []string{fs.config.LogFilePath, fs.config.HistoryDBPath} {
+		if _, err := os.Stat(path + rekeySuffix); err == nil {
+			staged = append(staged, path+rekeySuffix)
+		}
+	}
+	err := filepath.WalkDir(fs.imageDir, func(path string, d iofs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, rekeySuffix) {
+			staged = append(staged, path)
+		}
+		return nil
+	})
+	return staged, err
+}
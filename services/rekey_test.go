@@ -0,0 +1,251 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"clipmini/models"
+)
+
+var errCrash = errors.New("crash")
+
+// crashAt returns a crash function for rekeyFixture.rekey that fails before
+// or after the key info is committed.
+func crashAt(afterCommit bool) func(committed bool) error {
+	return func(committed bool) error {
+		if committed == afterCommit {
+			return errCrash
+		}
+		return nil
+	}
+}
+
+type rekeyFixture struct {
+	t      *testing.T
+	config *models.AppConfig
+	fs     *FileService
+	hs     *HistoryService
+	images map[string][]byte // content of each image item, by ID
+}
+
+func newRekeyFixture(t *testing.T, store string) *rekeyFixture {
+	t.Helper()
+	dir := t.TempDir()
+	config, err := models.LoadAppConfig(models.ResolveAppDirs(models.AppDirs{Data: dir, Config: dir, Cache: dir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.HistoryStore = store
+	f := &rekeyFixture{t: t, config: config, images: make(map[string][]byte)}
+	f.open("")
+	t.Cleanup(func() { f.hs.Close() })
+
+	if err := f.hs.AddItem(models.NewTextItem("hello")); err != nil {
+		t.Fatal(err)
+	}
+	// Two items share the first blob
+	for _, data := range [][]byte{[]byte("image one"), []byte("image two"), []byte("image one")} {
+		path, err := f.hs.SaveImage(data, "png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		item := models.NewImageItem(path)
+		if err := f.hs.AddItem(item); err != nil {
+			t.Fatal(err)
+		}
+		f.images[item.ID] = data
+	}
+	return f
+}
+
+// open starts over from what is on disk, the way the app does after a
+// crash: recover, unlock with secret when encrypted, load.
+func (f *rekeyFixture) open(secret string) {
+	f.t.Helper()
+	if f.hs != nil {
+		f.hs.Close()
+	}
+	f.fs = NewFileService(f.config)
+	f.hs = NewHistoryService(f.config, f.fs)
+	if err := f.fs.RecoverRekey(); err != nil {
+		f.t.Fatalf("RecoverRekey: %v", err)
+	}
+	ki, err := LoadKeyInfo(f.config.KeyInfoPath)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if ki != nil {
+		if err := f.fs.Vault().Unlock(ki, []byte(secret)); err != nil {
+			f.t.Fatalf("unlock with %q: %v", secret, err)
+		}
+	}
+	if err := f.hs.Load(); err != nil {
+		f.t.Fatalf("Load: %v", err)
+	}
+}
+
+// rekey does what ClipboardController.rekey does; crash is called in the
+// commit step, before or after the key info is committed.
+func (f *rekeyFixture) rekey(secret string, crash func(committed bool) error) error {
+	f.t.Helper()
+	ki, key, err := CreateKeyInfo(KeySourcePassphrase, []byte(secret))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if err := f.fs.StageKeyInfo(ki); err != nil {
+		f.t.Fatal(err)
+	}
+	return f.hs.Rekey(key, func() error {
+		if err := crash(false); err != nil {
+			return err
+		}
+		if err := f.fs.CommitKeyInfo(); err != nil {
+			return err
+		}
+		return crash(true)
+	})
+}
+
+// check verifies that every item and image is readable and that no staged
+// or stale file is left.
+func (f *rekeyFixture) check() {
+	f.t.Helper()
+	items := f.hs.GetItems()
+	if len(items) != 4 {
+		f.t.Fatalf("got %d items, want 4", len(items))
+	}
+	for _, item := range items {
+		want, ok := f.images[item.ID]
+		if !ok {
+			continue
+		}
+		got, err := f.fs.ReadImage(item.FilePath)
+		if err != nil || !bytes.Equal(got, want) {
+			f.t.Fatalf("image %s = %q, %v; want %q", item.FilePath, got, err, want)
+		}
+		if f.fs.Vault().Enabled() && strings.Contains(item.FilePath, ContentHash(want)) {
+			f.t.Fatalf("encrypted image %s is named after its SHA-256", item.FilePath)
+		}
+	}
+	report, err := f.hs.Fsck(false)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if len(report.Missing) > 0 || len(report.Orphaned) > 0 {
+		f.t.Fatalf("fsck: missing %v, orphaned %v", report.Missing, report.Orphaned)
+	}
+	if staged := f.staged(); len(staged) > 0 {
+		f.t.Fatalf("staged files left: %v", staged)
+	}
+}
+
+func (f *rekeyFixture) staged() []string {
+	var staged []string
+	filepath.WalkDir(f.config.Dirs.Data, func(path string, d iofs.DirEntry, err error) error {
+		if err == nil && strings.HasSuffix(path, rekeySuffix) {
+			staged = append(staged, path)
+		}
+		return nil
+	})
+	return staged
+}
+
+func TestRekeyRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		// crash interrupts the rekey; after runs before the restart, to
+		// stop at a point the rekey itself cannot be stopped at
+		crash   func(committed bool) error
+		after   func(f *rekeyFixture)
+		wantKey string
+	}{
+		{
+			name:    "fails before commit",
+			crash:   crashAt(false),
+			wantKey: "old",
+		},
+		{
+			name:  "crashes while staging",
+			crash: crashAt(false),
+			after: func(f *rekeyFixture) {
+				// Only the images were written
+				os.Remove(f.config.LogFilePath + rekeySuffix)
+				os.Remove(f.config.HistoryDBPath + rekeySuffix)
+				os.Remove(f.fs.renamesPath())
+			},
+			wantKey: "old",
+		},
+		{
+			name:    "crashes after commit",
+			crash:   crashAt(true),
+			wantKey: "new",
+		},
+		{
+			name:  "crashes while swapping",
+			crash: crashAt(true),
+			after: func(f *rekeyFixture) {
+				// One image was swapped in before the crash
+				for _, path := range f.staged() {
+					if strings.HasPrefix(path, f.config.ImageDirPath) && !strings.Contains(path, ".renames") {
+						os.Rename(path, strings.TrimSuffix(path, rekeySuffix))
+						return
+					}
+				}
+				f.t.Fatal("no staged image")
+			},
+			wantKey: "new",
+		},
+	}
+
+	for _, store := range []string{"jsonl", "bolt"} {
+		for _, tt := range tests {
+			t.Run(store+"/"+tt.name, func(t *testing.T) {
+				f := newRekeyFixture(t, store)
+				if err := f.rekey("old", func(bool) error { return nil }); err != nil {
+					t.Fatal(err)
+				}
+				f.check()
+
+				if err := f.rekey("new", tt.crash); !errors.Is(err, errCrash) {
+					t.Fatalf("rekey = %v, want the crash", err)
+				}
+				if tt.after != nil {
+					tt.after(f)
+				}
+				f.open(tt.wantKey)
+				f.check()
+			})
+		}
+	}
+}
+
+// TestRekeyRecoveryEnablingEncryption interrupts the first rekey, which
+// encrypts plaintext data, after it committed.
+func TestRekeyRecoveryEnablingEncryption(t *testing.T) {
+	for _, store := range []string{"jsonl", "bolt"} {
+		t.Run(store, func(t *testing.T) {
+			f := newRekeyFixture(t, store)
+			plainPaths := make(map[string]bool)
+			for _, item := range f.hs.GetItems() {
+				plainPaths[item.FilePath] = item.Type == models.ClipImage
+			}
+
+			err := f.rekey("secret", crashAt(true))
+			if !errors.Is(err, errCrash) {
+				t.Fatalf("rekey = %v, want the crash", err)
+			}
+			f.open("secret")
+			f.check()
+			for path, image := range plainPaths {
+				if _, err := os.Stat(path); image && err == nil {
+					t.Errorf("plaintext image %s is still there", path)
+				}
+			}
+		})
+	}
+}
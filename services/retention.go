@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"clipmini/models"
+)
+
+// RetentionPolicy evaluates the time-based retention rules on top of the
+// count limit and per-item expiry.
+type RetentionPolicy struct {
+	rules []retentionRule
+}
+
+type retentionRule struct {
+	name   string
+	query  *Query
+	maxAge time.Duration
+}
+
+func NewRetentionPolicy(rules []models.RetentionRule, loc *time.Location) (*RetentionPolicy, error) {
+	rp := &RetentionPolicy{}
+	for _, r := range rules {
+		if r.MaxAgeSeconds <= 0 {
+			return nil, fmt.Errorf("retention rule %q: max age must be positive", r.Name)
+		}
+		q, err := ParseQuery(r.Query, loc)
+		if err != nil {
+			return nil, fmt.Errorf("retention rule %q: %w", r.Name, err)
+		}
+		rp.rules = append(rp.rules, retentionRule{name: r.Name, query: q, maxAge: time.Duration(r.MaxAgeSeconds) * time.Second})
+	}
+	return rp, nil
+}
+
+// Deadline returns when the item expires: the earlier of its own expiry and
+// the shortest max age of the rules it matches. Zero means never.
+func (rp *RetentionPolicy) Deadline(item *models.ClipboardItem) time.Time {
+	deadline := item.ExpiresAt
+	for _, r := range rp.rules {
+		if _, ok := r.query.Match(item); !ok {
+			continue
+		}
+		if at := item.Timestamp.Add(r.maxAge); deadline.IsZero() || at.Before(deadline) {
+			deadline = at
+		}
+	}
+	return deadline
+}
+
+func (rp *RetentionPolicy) Expired(item *models.ClipboardItem, now time.Time) bool {
+	deadline := rp.Deadline(item)
+	return !deadline.IsZero() && !now.Before(deadline)
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"clipmini/models"
+)
+
+type SearchMode int
+
+const (
+	SearchSubstring SearchMode = iota
+	SearchWholeWord
+)
+
+// SearchIndex is an inverted index over text clips. Latin text is indexed by
+// word; CJK text has no word boundaries, so every character and every pair
+// of adjacent characters is indexed instead.
+type SearchIndex struct {
+	postings map[string]map[string]struct{}
+	terms    map[string][]string
+	items    map[string]*models.ClipboardItem
+}
+
+func NewSearchIndex() *SearchIndex {
+	si := &SearchIndex{}
+	si.Reset(nil)
+	return si
+}
+
+func (si *SearchIndex) Reset(items []*models.ClipboardItem) {
+	si.postings = make(map[string]map[string]struct{})
+	si.terms = make(map[string][]string)
+	si.items = make(map[string]*models.ClipboardItem)
+	for _, item := range items {
+		si.Add(item)
+	}
+}
+
+func (si *SearchIndex) Add(item *models.ClipboardItem) {
+	si.Remove(item.ID)
+	si.items[item.ID] = item
+
+	terms := uniqueTerms(Tokenize(item.Content))
+	si.terms[item.ID] = terms
+	for _, term := range terms {
+		ids := si.postings[term]
+		if ids == nil {
+			ids = make(map[string]struct{})
+			si.postings[term] = ids
+		}
+		ids[item.ID] = struct{}{}
+	}
+}
+
+func (si *SearchIndex) Remove(id string) {
+	for _, term := range si.terms[id] {
+		if ids := si.postings[term]; ids != nil {
+			delete(ids, id)
+			if len(ids) == 0 {
+				delete(si.postings, term)
+			}
+		}
+	}
+	delete(si.terms, id)
+	delete(si.items, id)
+}
+
+// Search returns the items matching every token of the query, newest first.
+// An empty query matches everything.
+func (si *SearchIndex) Search(query string, mode SearchMode) []*models.ClipboardItem {
+	tokens := tokenizeQuery(query)
+
+	var candidates map[string]struct{}
+	if len(tokens) == 0 {
+		candidates = make(map[string]struct{}, len(si.items))
+		for id := range si.items {
+			candidates[id] = struct{}{}
+		}
+	}
+	for _, tok := range tokens {
+		candidates = intersectIDs(candidates, si.lookup(tok, mode))
+		if len(candidates) == 0 {
+			return nil
+		}
+	}
+
+	results := make([]*models.ClipboardItem, 0, len(candidates))
+	for id := range candidates {
+		item := si.items[id]
+		if !containsAllTokens(item.Content, tokens, mode) {
+			continue
+		}
+		results = append(results, item)
+	}
+	sortByRecency(results)
+	return results
+}
+
+func (si *SearchIndex) lookup(tok queryToken, mode SearchMode) map[string]struct{} {
+	if tok.cjk {
+		runes := []rune(tok.text)
+		if len(runes) == 1 {
+			return si.postings[tok.text]
+		}
+		var ids map[string]struct{}
+		for i := 0; i+1 < len(runes); i++ {
+			ids = intersectIDs(ids, si.postings[string(runes[i:i+2])])
+			if len(ids) == 0 {
+				return nil
+			}
+		}
+		return ids
+	}
+
+	if mode == SearchWholeWord {
+		return si.postings[tok.text]
+	}
+	ids := make(map[string]struct{})
+	for term, termIDs := range si.postings {
+		if strings.Contains(term, tok.text) {
+			for id := range termIDs {
+				ids[id] = struct{}{}
+			}
+		}
+	}
+	return ids
+}
+
+// intersectIDs treats a nil set as "everything".
+func intersectIDs(a, b map[string]struct{}) map[string]struct{} {
+	if a == nil {
+		out := make(map[string]struct{}, len(b))
+		for id := range b {
+			out[id] = struct{}{}
+		}
+		return out
+	}
+	for id := range a {
+		if _, ok := b[id]; !ok {
+			delete(a, id)
+		}
+	}
+	return a
+}
+
+// containsAllTokens weeds out false positives from the index: substring
+// tokens that span word boundaries and CJK bigrams that are not adjacent.
+func containsAllTokens(content string, tokens []queryToken, mode SearchMode) bool {
+	lower := strings.ToLower(content)
+	for _, tok := range tokens {
+		if (mode == SearchSubstring || tok.cjk) && !strings.Contains(lower, tok.text) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortByRecency(items []*models.ClipboardItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Timestamp.After(items[j].Timestamp)
+	})
+}
+
+type queryToken struct {
+	text string
+	cjk  bool
+}
+
+// tokenizeQuery splits a query into lowercase words and CJK runs.
+func tokenizeQuery(s string) []queryToken {
+	var tokens []queryToken
+	scanWords(s, func(word string, cjk bool) {
+		tokens = append(tokens, queryToken{text: word, cjk: cjk})
+	})
+	return tokens
+}
+
+// Tokenize returns the index terms of s: lowercase words, plus unigrams and
+// bigrams of every CJK run.
+func Tokenize(s string) []string {
+	var terms []string
+	scanWords(s, func(word string, cjk bool) {
+		if !cjk {
+			terms = append(terms, word)
+			return
+		}
+		runes := []rune(word)
+		for i := range runes {
+			terms = append(terms, string(runes[i]))
+			if i+1 < len(runes) {
+				terms = append(terms, string(runes[i:i+2]))
+			}
+		}
+	})
+	return terms
+}
+
+func scanWords(s string, emit func(word string, cjk bool)) {
+	var word []rune
+	wordIsCJK := false
+	flush := func() {
+		if len(word) > 0 {
+			emit(string(word), wordIsCJK)
+			word = word[:0]
+		}
+	}
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case isCJK(r):
+			if !wordIsCJK {
+				flush()
+				wordIsCJK = true
+			}
+			word = append(word, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			if wordIsCJK {
+				flush()
+				wordIsCJK = false
+			}
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+}
+
+func isCJK(r rune) bool {
+	// The prolonged sound mark belongs to no script but only appears in kana
+	if r == 'ー' || r == 'ｰ' {
+		return true
+	}
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Bopomofo)
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]struct{}, len(terms))
+	out := terms[:0]
+	for _, t := range terms {
+		if _, ok := seen[t]; !ok {
+			seen[t] = struct{}{}
+			out = append(out, t)
+		}
+	}
+	return out
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"clipmini/models"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"Hello, World_2", []string{"hello", "world_2"}},
+		{"中文", []string{"中", "中文", "文"}},
+		{"搜尋引擎", []string{"搜", "搜尋", "尋", "尋引", "引", "引擎", "擎"}},
+		// Latin and CJK runs split where the script changes
+		{"用Go寫的app", []string{"用", "go", "寫", "寫的", "的", "app"}},
+		{"東京 Tokyo タワー", []string{"東", "東京", "京", "tokyo", "タ", "タワ", "ワ", "ワー", "ー"}},
+		{"ラーメン", []string{"ラ", "ラー", "ー", "ーメ", "メ", "メン", "ン"}},
+		{"한국어", []string{"한", "한국", "국", "국어", "어"}},
+		{"中，文", []string{"中", "文"}},
+		{"  ...  ", nil},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// indexTestItem returns a text item whose time orders it by n.
+func indexTestItem(id, content string, n int) *models.ClipboardItem {
+	item := models.NewTextItem(content)
+	item.ID = id
+	item.Timestamp = time.Date(2024, 1, 1, 0, 0, n, 0, time.UTC)
+	return item
+}
+
+func searchIDs(si *SearchIndex, query string, mode SearchMode) []string {
+	ids := []string{}
+	for _, item := range si.Search(query, mode) {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestSearchIndex(t *testing.T) {
+	si := NewSearchIndex()
+	si.Reset([]*models.ClipboardItem{
+		indexTestItem("a", "今天天氣很好", 1),
+		indexTestItem("b", "明天見 see you tomorrow", 2),
+		indexTestItem("c", "天文學 astronomy notes", 3),
+		indexTestItem("d", "golang 程式設計", 4),
+	})
+
+	tests := []struct {
+		query string
+		mode  SearchMode
+		want  []string
+	}{
+		// A single CJK character matches wherever it occurs, newest first
+		{"天", SearchSubstring, []string{"c", "b", "a"}},
+		{"天", SearchWholeWord, []string{"c", "b", "a"}},
+		{"見", SearchSubstring, []string{"b"}},
+		{"天氣", SearchSubstring, []string{"a"}},
+		{"今天天氣", SearchSubstring, []string{"a"}},
+		// Both bigrams occur, but not next to each other
+		{"天今", SearchSubstring, []string{}},
+		{"程式 go", SearchSubstring, []string{"d"}},
+		{"go", SearchWholeWord, []string{}},
+		{"golang程式", SearchSubstring, []string{"d"}},
+		{"天 astro", SearchSubstring, []string{"c"}},
+		{"TOMORROW", SearchWholeWord, []string{"b"}},
+		{"月", SearchSubstring, []string{}},
+		{"", SearchSubstring, []string{"d", "c", "b", "a"}},
+	}
+	for _, tt := range tests {
+		if got := searchIDs(si, tt.query, tt.mode); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q, %v) = %v, want %v", tt.query, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestSearchIndexUpdates(t *testing.T) {
+	si := NewSearchIndex()
+	si.Add(indexTestItem("a", "今天天氣很好", 1))
+	si.Add(indexTestItem("b", "明天下雨", 2))
+	if got := searchIDs(si, "天", SearchSubstring); !reflect.DeepEqual(got, []string{"b", "a"}) {
+		t.Fatalf("after add: %v", got)
+	}
+
+	// An edit adds the item again under the same ID
+	si.Add(indexTestItem("a", "晴朗 sunny", 1))
+	for query, want := range map[string][]string{
+		"天氣":    {},
+		"天":     {"b"},
+		"晴":     {"a"},
+		"sunny": {"a"},
+	} {
+		if got := searchIDs(si, query, SearchSubstring); !reflect.DeepEqual(got, want) {
+			t.Errorf("after edit Search(%q) = %v, want %v", query, got, want)
+		}
+	}
+	if terms, want := si.terms["a"], []string{"晴", "晴朗", "朗", "sunny"}; !reflect.DeepEqual(terms, want) {
+		t.Errorf("edited item has terms %q, want the new ones only", terms)
+	}
+
+	si.Remove("b")
+	si.Remove("missing")
+	if got := searchIDs(si, "天", SearchSubstring); len(got) != 0 {
+		t.Errorf("after delete Search(天) = %v, want none", got)
+	}
+	if got := searchIDs(si, "", SearchSubstring); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("after delete the index holds %v, want [a]", got)
+	}
+	// No posting is left behind for a removed item
+	for term, ids := range si.postings {
+		if _, ok := ids["b"]; ok {
+			t.Errorf("term %q still lists the removed item", term)
+		}
+		if len(ids) == 0 {
+			t.Errorf("term %q has an empty posting list", term)
+		}
+	}
+}
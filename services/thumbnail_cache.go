@@ -0,0 +1,214 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"clipmini/models"
+)
+
+const (
+	// ThumbnailSize is the longest side of a thumbnail in pixels, twice what
+	// the list shows so it stays sharp on HiDPI screens.
+	ThumbnailSize = 64
+
+	thumbnailMemoryEntries = 256
+)
+
+// ThumbnailCache builds image thumbnails on a pool of workers and keeps them
+// on disk under the cache directory, sealed like the images themselves, and
+// the most recent ones in memory.
+type ThumbnailCache struct {
+	fileService *FileService
+	mu          sync.Mutex
+	cond        *sync.Cond
+	memory      map[string]image.Image
+	order       []string // memory keys, oldest first
+	failed      map[string]bool
+	// gen counts Forgets and Resets, so work started before one is not kept
+	gen uint64
+	// queue is served last in, first out, so the rows on screen are done
+	// before the ones scrolled past.
+	queue   []string
+	waiting map[string][]func()
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func NewThumbnailCache(fileService *FileService, workers int) *ThumbnailCache {
+	tc := &ThumbnailCache{
+		fileService: fileService,
+		memory:      make(map[string]image.Image),
+		failed:      make(map[string]bool),
+		waiting:     make(map[string][]func()),
+	}
+	tc.cond = sync.NewCond(&tc.mu)
+	fileService.thumbnails = tc
+	for range max(workers, 1) {
+		tc.wg.Add(1)
+		go tc.work()
+	}
+	return tc
+}
+
+// Get returns the thumbnail of an image item when it is in memory. Otherwise
+// it queues the item and calls ready from a worker once the thumbnail is, or
+// never when the image cannot be read.
+func (tc *ThumbnailCache) Get(item *models.ClipboardItem, ready func()) image.Image {
+	if item.Type != models.ClipImage || item.FilePath == "" {
+		return nil
+	}
+	path := item.FilePath
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if img, ok := tc.memory[path]; ok {
+		return img
+	}
+	if tc.closed || tc.failed[path] {
+		return nil
+	}
+	if _, queued := tc.waiting[path]; !queued {
+		tc.queue = append(tc.queue, path)
+		tc.cond.Signal()
+	}
+	tc.waiting[path] = append(tc.waiting[path], ready)
+	return nil
+}
+
+// Forget drops what is known about the thumbnails of paths, e.g. after the
+// images were deleted.
+func (tc *ThumbnailCache) Forget(paths ...string) {
+	if tc == nil {
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	for _, path := range paths {
+		delete(tc.memory, path)
+		delete(tc.failed, path)
+	}
+	tc.gen++
+	kept := tc.order[:0]
+	for _, path := range tc.order {
+		if _, ok := tc.memory[path]; ok {
+			kept = append(kept, path)
+		}
+	}
+	tc.order = kept
+}
+
+// Reset forgets every thumbnail and failure, e.g. after unlocking or a key
+// change, when images that could not be read before may be readable now.
+func (tc *ThumbnailCache) Reset() {
+	if tc == nil {
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.memory = make(map[string]image.Image)
+	tc.order = nil
+	tc.failed = make(map[string]bool)
+	tc.gen++
+}
+
+// Close stops the workers once the thumbnail being built is done.
+func (tc *ThumbnailCache) Close() {
+	tc.mu.Lock()
+	tc.closed = true
+	tc.cond.Broadcast()
+	tc.mu.Unlock()
+	tc.wg.Wait()
+}
+
+func (tc *ThumbnailCache) work() {
+	defer tc.wg.Done()
+	for {
+		tc.mu.Lock()
+		for len(tc.queue) == 0 && !tc.closed {
+			tc.cond.Wait()
+		}
+		if tc.closed {
+			tc.mu.Unlock()
+			return
+		}
+		path := tc.queue[len(tc.queue)-1]
+		tc.queue = tc.queue[:len(tc.queue)-1]
+		gen := tc.gen
+		tc.mu.Unlock()
+
+		img, err := tc.load(path)
+
+		tc.mu.Lock()
+		callbacks := tc.waiting[path]
+		delete(tc.waiting, path)
+		if gen != tc.gen {
+			// Forgotten while loading; let the rows ask again
+			err = nil
+		} else if err != nil {
+			log.Printf("Failed to build thumbnail of %s: %v", path, err)
+			tc.failed[path] = true
+		} else {
+			tc.remember(path, img)
+		}
+		tc.mu.Unlock()
+
+		if err == nil {
+			for _, ready := range callbacks {
+				if ready != nil {
+					ready()
+				}
+			}
+		}
+	}
+}
+
+// remember keeps img in memory, evicting the oldest entry when full.
+func (tc *ThumbnailCache) remember(path string, img image.Image) {
+	if len(tc.order) >= thumbnailMemoryEntries {
+		delete(tc.memory, tc.order[0])
+		tc.order = tc.order[1:]
+	}
+	tc.memory[path] = img
+	tc.order = append(tc.order, path)
+}
+
+// load reads the thumbnail from disk, or builds and stores it.
+func (tc *ThumbnailCache) load(path string) (image.Image, error) {
+	thumbPath := tc.fileService.ThumbnailPath(path)
+	if data, err := tc.fileService.ReadImage(thumbPath); err == nil {
+		if img, err := png.Decode(bytes.NewReader(data)); err == nil {
+			return img, nil
+		}
+	}
+
+	data, err := tc.fileService.ReadImage(path)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if scale := scaleToFit(b.Dx(), b.Dy(), ThumbnailSize); scale < 1 {
+		img = resizeImage(img, scale)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(thumbPath), 0o700); err != nil {
+		return nil, err
+	}
+	if err := tc.fileService.WriteImage(thumbPath, buf.Bytes()); err != nil {
+		log.Printf("Failed to cache thumbnail of %s: %v", path, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+
+	"clipmini/utils"
+)
+
+// Sealed data is sealedMagic | nonce | AES-256-GCM ciphertext. The prefix lets
+// plaintext written before encryption was enabled be read while no key is
+// set, so a store can be migrated in place. Once a key is set, data without
+// it is refused: it could have been put there by anyone.
+var sealedMagic = []byte("CLIPENC1")
+
+var (
+	ErrLocked          = errors.New("encrypted data is locked")
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+	ErrNotSealed       = errors.New("data is not encrypted")
+)
+
+// blobNameLabel derives the key that names image blobs from the data key.
+var blobNameLabel = []byte("clipmini blob names")
+
+const (
+	KeySourcePassphrase = "passphrase"
+	KeySourceKeyFile    = "keyfile"
+)
+
+const (
+	keyInfoVersion = 1
+	scryptN        = 1 << 15
+	scryptR        = 8
+	scryptP        = 1
+	vaultKeyLength = 32
+	keyFileMinSize = 16
+)
+
+var keyCheckPlaintext = []byte("clipmini")
+
+// KeyInfo describes how the data key is derived from a passphrase or key
+// file. It holds no secret and is stored next to the history.
+type KeyInfo struct {
+	Version int    `json:"version"`
+	Source  string `json:"source"`
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Check   []byte `json:"check"` // keyCheckPlaintext sealed with the key
+}
+
+// CreateKeyInfo derives a new data key from secret with a fresh salt.
+func CreateKeyInfo(source string, secret []byte) (*KeyInfo, []byte, error) {
+	ki := &KeyInfo{Version: keyInfoVersion, Source: source, KDF: "scrypt", Salt: make([]byte, 16), N: scryptN, R: scryptR, P: scryptP}
+	if _, err := rand.Read(ki.Salt); err != nil {
+		return nil, nil, err
+	}
+	key, err := ki.deriveKey(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newVaultAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ki.Check, err = seal(aead, keyCheckPlaintext); err != nil {
+		return nil, nil, err
+	}
+	return ki, key, nil
+}
+
+func (ki *KeyInfo) deriveKey(secret []byte) ([]byte, error) {
+	if ki.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation %q", ki.KDF)
+	}
+	return scrypt.Key(secret, ki.Salt, ki.N, ki.R, ki.P, vaultKeyLength)
+}
+
+// Verify derives the data key from secret and checks it against the stored
+// check value.
+func (ki *KeyInfo) Verify(secret []byte) ([]byte, error) {
+	key, err := ki.deriveKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newVaultAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if plain, err := open(aead, ki.Check); err != nil || !bytes.Equal(plain, keyCheckPlaintext) {
+		return nil, ErrWrongPassphrase
+	}
+	return key, nil
+}
+
+// LoadKeyInfo returns nil without error when encryption was never enabled.
+func LoadKeyInfo(path string) (*KeyInfo, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ki KeyInfo
+	if err := json.Unmarshal(data, &ki); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if ki.Version > keyInfoVersion {
+		return nil, fmt.Errorf("%s: unsupported key info v%d", path, ki.Version)
+	}
+	return &ki, nil
+}
+
+func SaveKeyInfo(path string, ki *KeyInfo) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(ki, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data, 0o600)
+}
+
+// ReadKeyFile reads the secret of a key file.
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) < keyFileMinSize {
+		return nil, fmt.Errorf("key file %s is shorter than %d bytes", path, keyFileMinSize)
+	}
+	return data, nil
+}
+
+// Vault seals data at rest once a key is set; until then it passes data
+// through unchanged and refuses to open sealed data.
+type Vault struct {
+	mu   sync.RWMutex
+	aead cipher.AEAD
+	// nameKey keys the hash that names image blobs, so their names do not
+	// tell which images are stored
+	nameKey []byte
+}
+
+func NewVault() *Vault {
+	return &Vault{}
+}
+
+func (v *Vault) SetKey(key []byte) error {
+	aead, err := newVaultAEAD(key)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(blobNameLabel)
+	v.mu.Lock()
+	v.aead = aead
+	v.nameKey = mac.Sum(nil)
+	v.mu.Unlock()
+	return nil
+}
+
+// Unlock verifies secret against ki and sets the derived key.
+func (v *Vault) Unlock(ki *KeyInfo, secret []byte) error {
+	key, err := ki.Verify(secret)
+	if err != nil {
+		return err
+	}
+	return v.SetKey(key)
+}
+
+func (v *Vault) Enabled() bool {
+	if v == nil {
+		return false
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.aead != nil
+}
+
+func (v *Vault) Seal(plain []byte) ([]byte, error) {
+	if v == nil {
+		return plain, nil
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.aead == nil {
+		return plain, nil
+	}
+	return seal(v.aead, plain)
+}
+
+func (v *Vault) Open(data []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		if v.Enabled() {
+			return nil, ErrNotSealed
+		}
+		return data, nil
+	}
+	if v == nil {
+		return nil, ErrLocked
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.aead == nil {
+		return nil, ErrLocked
+	}
+	return open(v.aead, data)
+}
+
+// BlobName is the hex name of the blob holding data: its SHA-256, or with a
+// key set, an HMAC-SHA256 under a key derived from it.
+func (v *Vault) BlobName(data []byte) string {
+	if v == nil {
+		return ContentHash(data)
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.nameKey == nil {
+		return ContentHash(data)
+	}
+	mac := hmac.New(sha256.New, v.nameKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedMagic)
+}
+
+func newVaultAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != vaultKeyLength {
+		return nil, fmt.Errorf("vault key must be %d bytes", vaultKeyLength)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plain []byte) ([]byte, error) {
+	out := make([]byte, len(sealedMagic)+aead.NonceSize(), len(sealedMagic)+aead.NonceSize()+len(plain)+aead.Overhead())
+	copy(out, sealedMagic)
+	nonce := out[len(sealedMagic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, plain, sealedMagic), nil
+}
+
+func open(aead cipher.AEAD, data []byte) ([]byte, error) {
+	body := data[len(sealedMagic):]
+	if len(body) < aead.NonceSize() {
+		return nil, errors.New("sealed data is truncated")
+	}
+	plain, err := aead.Open(nil, body[:aead.NonceSize()], body[aead.NonceSize():], sealedMagic)
+	if err != nil {
+		return nil, fmt.Errorf("sealed data: %w", err)
+	}
+	return plain, nil
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"clipmini/models"
+)
+
+func newTestVault(t *testing.T, fill byte) *Vault {
+	t.Helper()
+	v := NewVault()
+	if err := v.SetKey(bytes.Repeat([]byte{fill}, vaultKeyLength)); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestVaultRefusesPlaintextOnceKeyed(t *testing.T) {
+	plain := []byte("not sealed")
+	if got, err := NewVault().Open(plain); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("without a key Open = %q, %v; want the data back", got, err)
+	}
+
+	v := newTestVault(t, 1)
+	if _, err := v.Open(plain); !errors.Is(err, ErrNotSealed) {
+		t.Fatalf("with a key Open(plaintext) = %v, want ErrNotSealed", err)
+	}
+	sealed, err := v.Seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := v.Open(sealed); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("Open(Seal(x)) = %q, %v", got, err)
+	}
+	if _, err := newTestVault(t, 2).Open(sealed); err == nil {
+		t.Fatal("data sealed with another key opened")
+	}
+}
+
+func TestDecodeHistoryRecordsRefusesPlaintextOnceKeyed(t *testing.T) {
+	records := []models.HistoryRecord{models.NewTextItem("hello").ToRecord()}
+	plain, err := EncodeHistoryRecords(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := newTestVault(t, 1)
+	if _, err := decodeHistoryRecords(bytes.NewReader(plain), "history", v); !errors.Is(err, ErrNotSealed) {
+		t.Fatalf("plaintext history with a key: err = %v, want ErrNotSealed", err)
+	}
+
+	// A header claiming encryption over a record that is not sealed
+	forged := []byte(`{"schema":"` + models.HistorySchema + `","version":1,"encrypted":true}` + "\n" +
+		`{"sealed":"` + "eyJpZCI6IjEifQ==" + `"}` + "\n")
+	if _, err := decodeHistoryRecords(bytes.NewReader(forged), "history", v); !errors.Is(err, ErrNotSealed) {
+		t.Fatalf("unsealed record in an encrypted history: err = %v, want ErrNotSealed", err)
+	}
+
+	sealed, err := encodeHistoryRecords(records, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeHistoryRecords(bytes.NewReader(sealed), "history", v)
+	if err != nil || len(got) != 1 || got[0].Content != "hello" {
+		t.Fatalf("round trip = %v, %v", got, err)
+	}
+}
+
+func TestVaultBlobNameIsKeyed(t *testing.T) {
+	data := []byte("some image")
+	if got := NewVault().BlobName(data); got != ContentHash(data) {
+		t.Fatalf("without a key BlobName = %s, want the SHA-256", got)
+	}
+	one, two := newTestVault(t, 1).BlobName(data), newTestVault(t, 2).BlobName(data)
+	if one == ContentHash(data) || one == two {
+		t.Fatalf("keyed names %s and %s must differ from each other and the SHA-256", one, two)
+	}
+	if len(one) != 64 {
+		t.Fatalf("keyed name %s is not 64 hex digits", one)
+	}
+}
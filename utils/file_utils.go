@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteFileAtomic writes data to a new temporary file next to path and
+// renames it over path, so readers see the old or the new content, never
+// part of it.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// IsAtomicTemp reports whether name is a temporary file WriteFileAtomic left
+// next to path.
+func IsAtomicTemp(name, path string) bool {
+	return filepath.Dir(name) == filepath.Dir(path) &&
+		strings.HasPrefix(filepath.Base(name), "."+filepath.Base(path)+".tmp")
+}
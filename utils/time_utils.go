@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -8,7 +9,27 @@ func FormatTimestamp(t time.Time, location *time.Location) string {
 	return t.In(location).Format("2006-01-02 15:04:05")
 }
 
-func GetTaipeiLocation() *time.Location {
-	loc, _ := time.LoadLocation("Asia/Taipei")
-	return loc
-}
\ No newline at end of file
+// FormatTime renders t in location with a Go layout.
+func FormatTime(t time.Time, location *time.Location, layout string) string {
+	return t.In(location).Format(layout)
+}
+
+// FormatRelative renders t relative to now, "3 分鐘前", falling back to layout
+// once it is a week old.
+func FormatRelative(t, now time.Time, location *time.Location, layout string) string {
+	d := now.Sub(t)
+	switch {
+	case d < 0:
+		return FormatTime(t, location, layout)
+	case d < time.Minute:
+		return "剛剛"
+	case d < time.Hour:
+		return fmt.Sprintf("%d 分鐘前", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%d 小時前", int(d/time.Hour))
+	case d < 7*24*time.Hour:
+		return fmt.Sprintf("%d 天前", int(d/(24*time.Hour)))
+	default:
+		return FormatTime(t, location, layout)
+	}
+}
@@ -0,0 +1,115 @@
+package views
+
+import (
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// sleepGap is how far the wall clock may jump between two idle checks before
+// we assume the system slept.
+const sleepGap = 30 * time.Second
+
+// touch records user activity for the idle lock.
+func (mv *MainView) touch() {
+	mv.lastActivity = time.Now()
+}
+
+// inBackground runs fn without counting the selection changes it causes as
+// user activity.
+func (mv *MainView) inBackground(fn func()) {
+	lastActivity := mv.lastActivity
+	fn()
+	mv.lastActivity = lastActivity
+}
+
+// CheckIdle locks the window after the configured idle period, or when the
+// wall clock jumped since the last check, which means the system slept.
+// Capture keeps running while locked.
+func (mv *MainView) CheckIdle(now time.Time) {
+	fyne.Do(func() {
+		now := now.Round(0) // the monotonic clock stops while asleep
+		slept := !mv.lastCheck.IsZero() && now.Sub(mv.lastCheck) > sleepGap
+		mv.lastCheck = now
+
+		if mv.locked || !mv.clipboardController.LockConfigured() {
+			return
+		}
+		idle := time.Duration(mv.config.LockAfterSeconds) * time.Second
+		if slept || (idle > 0 && now.Sub(mv.lastActivity.Round(0)) >= idle) {
+			mv.Lock()
+		}
+	})
+}
+
+// Lock masks the list, the collections and the preview until the
+// passphrase is entered.
+func (mv *MainView) Lock() {
+	if mv.locked {
+		return
+	}
+	mv.locked = true
+	mv.listView.SetMasked(true)
+	mv.detailView.SetMasked(true)
+	mv.searchEntry.Disable()
+	mv.collectionList.Refresh()
+	mv.toolbar.SetLocked(true)
+	mv.updateStatus("已鎖定，仍在背景記錄")
+}
+
+func (mv *MainView) unlock() {
+	mv.locked = false
+	mv.listView.SetMasked(false)
+	mv.detailView.SetMasked(false)
+	mv.searchEntry.Enable()
+	mv.refreshCollections()
+	mv.toolbar.SetLocked(false)
+	mv.touch()
+	mv.updateStatus("已解鎖")
+}
+
+func (mv *MainView) onLockButton() {
+	switch {
+	case mv.locked:
+		mv.promptUnlock()
+	case mv.clipboardController.LockConfigured():
+		mv.Lock()
+	default:
+		mv.promptLockPassphrase()
+	}
+}
+
+func (mv *MainView) promptUnlock() {
+	showPassphrasePrompt(mv.window, "🔒 解鎖", "請輸入密碼", "取消",
+		mv.clipboardController.VerifyLockPassphrase, mv.unlock, nil)
+}
+
+// promptLockPassphrase asks for a lock passphrase the first time the window
+// is locked without an encrypted history, then locks.
+func (mv *MainView) promptLockPassphrase() {
+	next := widget.NewPasswordEntry()
+	confirm := widget.NewPasswordEntry()
+	items := []*widget.FormItem{
+		widget.NewFormItem("解鎖密碼", next),
+		widget.NewFormItem("確認密碼", confirm),
+	}
+
+	form := dialog.NewForm("設定解鎖密碼", "鎖定", "取消", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		if next.Text != confirm.Text {
+			mv.updateStatus("兩次輸入的密碼不同")
+			return
+		}
+		if err := mv.clipboardController.SetLockPassphrase(next.Text); err != nil {
+			mv.updateStatus("設定密碼失敗: " + err.Error())
+			return
+		}
+		mv.Lock()
+	}, mv.window)
+	form.Resize(fyne.NewSize(360, 200))
+	form.Show()
+}
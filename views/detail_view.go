@@ -1,6 +1,8 @@
 package views
 
 import (
+	"fmt"
+	"path/filepath"
 	"strings"
 
 	"fyne.io/fyne/v2"
@@ -9,20 +11,36 @@ import (
 	"fyne.io/fyne/v2/widget"
 
 	"clipmini/models"
+	"clipmini/utils"
 )
 
 type DetailView struct {
+	config      *models.AppConfig
 	container   *fyne.Container
 	textEntry   *widget.Entry
 	imageCard   *widget.Card
 	saveButton  *widget.Button
+	keepBestBtn *widget.Button
 	currentItem *models.ClipboardItem
 	onSave      func(string)
+	onKeepBest  func()
 	originalText string
+
+	collectionEntry *widget.SelectEntry
+	tagsEntry       *widget.Entry
+	labelsButton    *widget.Button
+	labelsBar       *fyne.Container
+	onLabels        func(collection string, tags []string)
+	loadImage       func(*models.ClipboardItem) ([]byte, error)
+
+	lockPanel *fyne.Container
+	masked    bool
+	onUnlock  func()
 }
 
-func NewDetailView() *DetailView {
+func NewDetailView(config *models.AppConfig) *DetailView {
 	dv := &DetailView{
+		config:    config,
 		textEntry: widget.NewMultiLineEntry(),
 	}
 	
@@ -36,6 +54,13 @@ func NewDetailView() *DetailView {
 	})
 	dv.saveButton.Hide()
 	
+	dv.keepBestBtn = widget.NewButton("✨ 保留最佳", func() {
+		if dv.onKeepBest != nil {
+			dv.onKeepBest()
+		}
+	})
+	dv.keepBestBtn.Hide()
+	
 	// Monitor text changes to show/hide save button
 	dv.textEntry.OnChanged = func(text string) {
 		if dv.originalText != text && dv.currentItem != nil && dv.currentItem.Type == models.ClipText {
@@ -45,7 +70,29 @@ func NewDetailView() *DetailView {
 		}
 	}
 	
-	buttonContainer := container.NewHBox(dv.saveButton)
+	dv.collectionEntry = widget.NewSelectEntry(nil)
+	dv.collectionEntry.SetPlaceHolder("集合")
+	dv.tagsEntry = widget.NewEntry()
+	dv.tagsEntry.SetPlaceHolder("標籤，以逗號分隔")
+	dv.labelsButton = widget.NewButton("🏷️ 套用", func() {
+		if dv.onLabels != nil {
+			dv.onLabels(dv.collectionEntry.Text, strings.Split(dv.tagsEntry.Text, ","))
+		}
+	})
+	dv.labelsBar = container.NewBorder(nil, nil, nil, dv.labelsButton,
+		container.NewGridWithColumns(2, dv.collectionEntry, dv.tagsEntry))
+	dv.labelsBar.Hide()
+	
+	dv.lockPanel = container.NewCenter(container.NewVBox(
+		widget.NewLabelWithStyle("🔒 已鎖定", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
+		widget.NewButton("解鎖", func() {
+			if dv.onUnlock != nil {
+				dv.onUnlock()
+			}
+		}),
+	))
+	
+	buttonContainer := container.NewVBox(dv.labelsBar, container.NewHBox(dv.saveButton, dv.keepBestBtn))
 	dv.container = container.NewBorder(nil, buttonContainer, nil, nil, dv.textEntry)
 	
 	return dv
@@ -57,6 +104,12 @@ func (dv *DetailView) GetWidget() *fyne.Container {
 
 func (dv *DetailView) ShowItem(item *models.ClipboardItem) {
 	dv.currentItem = item
+	if dv.masked {
+		return
+	}
+	dv.collectionEntry.SetText(item.Collection)
+	dv.tagsEntry.SetText(strings.Join(item.Tags, ", "))
+	dv.labelsBar.Show()
 	
 	if item.Type == models.ClipImage {
 		dv.showImage(item)
@@ -76,13 +129,16 @@ func (dv *DetailView) showText(item *models.ClipboardItem) {
 }
 
 func (dv *DetailView) showImage(item *models.ClipboardItem) {
-	if res, err := fyne.LoadResourceFromPath(item.FilePath); err == nil {
-		img := canvas.NewImageFromResource(res)
+	if data, err := dv.loadImage(item); err == nil {
+		img := canvas.NewImageFromResource(fyne.NewStaticResource(filepath.Base(item.FilePath), data))
 		img.FillMode = canvas.ImageFillContain
 		img.SetMinSize(fyne.NewSize(360, 260))
 		
-		timestamp := item.Timestamp.Format("2006-01-02 15:04:05")
-		dv.imageCard = widget.NewCard("Image", timestamp, img)
+		subtitle := utils.FormatTime(item.Timestamp, dv.config.Location(), dv.config.TimestampFormat)
+		if o := item.Original; o != nil {
+			subtitle += fmt.Sprintf(" · 原始 %d×%d %s", o.Width, o.Height, strings.ToUpper(o.Format))
+		}
+		dv.imageCard = widget.NewCard("Image", subtitle, img)
 		
 		dv.textEntry.Hide()
 		dv.container.Objects[0] = dv.imageCard
@@ -96,6 +152,31 @@ func (dv *DetailView) showImage(item *models.ClipboardItem) {
 	}
 }
 
+// SetMasked replaces the preview with a lock panel; unmasking shows the
+// current item again.
+func (dv *DetailView) SetMasked(masked bool) {
+	dv.masked = masked
+	if !masked {
+		if dv.currentItem != nil {
+			dv.ShowItem(dv.currentItem)
+		} else {
+			dv.Clear()
+		}
+		return
+	}
+	
+	dv.saveButton.Hide()
+	dv.keepBestBtn.Hide()
+	dv.labelsBar.Hide()
+	dv.imageCard = nil
+	dv.container.Objects[0] = dv.lockPanel
+	dv.container.Refresh()
+}
+
+func (dv *DetailView) SetOnUnlock(callback func()) {
+	dv.onUnlock = callback
+}
+
 func (dv *DetailView) ShowError(message string) {
 	dv.textEntry.SetText("[ERROR] " + message)
 	dv.textEntry.Show()
@@ -108,9 +189,14 @@ func (dv *DetailView) Clear() {
 	dv.textEntry.SetText("")
 	dv.textEntry.Show()
 	dv.saveButton.Hide()
+	dv.keepBestBtn.Hide()
 	dv.imageCard = nil
 	dv.currentItem = nil
 	dv.originalText = ""
+	dv.labelsBar.Hide()
+	if dv.masked {
+		return
+	}
 	dv.container.Objects[0] = dv.textEntry
 	dv.container.Refresh()
 }
@@ -125,4 +211,34 @@ func (dv *DetailView) GetCurrentItem() *models.ClipboardItem {
 
 func (dv *DetailView) SetOnSave(callback func(string)) {
 	dv.onSave = callback
-}
\ No newline at end of file
+}
+
+// SetNearDuplicates offers to keep only the best capture when the current
+// item has count similar images, counting itself.
+func (dv *DetailView) SetNearDuplicates(count int) {
+	if count < 2 || dv.masked {
+		dv.keepBestBtn.Hide()
+		return
+	}
+	dv.keepBestBtn.SetText(fmt.Sprintf("✨ 保留最佳（%d 張相似）", count))
+	dv.keepBestBtn.Show()
+}
+
+func (dv *DetailView) SetOnKeepBest(callback func()) {
+	dv.onKeepBest = callback
+}
+
+// SetImageLoader sets how image items are read; saved images may be
+// encrypted, so they cannot be loaded from their path directly.
+func (dv *DetailView) SetImageLoader(loader func(*models.ClipboardItem) ([]byte, error)) {
+	dv.loadImage = loader
+}
+
+func (dv *DetailView) SetOnLabels(callback func(collection string, tags []string)) {
+	dv.onLabels = callback
+}
+
+// SetCollections sets the suggestions offered by the collection picker.
+func (dv *DetailView) SetCollections(names []string) {
+	dv.collectionEntry.SetOptions(names)
+}
@@ -1,189 +1,333 @@
 package views
 
 import (
-	"strings"
+	"fmt"
+	"image"
+	"time"
 
 	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
-	"fyne.io/fyne/v2/data/binding"
 	"fyne.io/fyne/v2/widget"
 
 	"clipmini/models"
+	"clipmini/services"
 	"clipmini/utils"
 )
 
+// thumbnailSide is how large thumbnails are shown in the list.
+const thumbnailSide = 32
+
 type ListView struct {
-	list        *widget.List
-	historyData binding.StringList
-	config      *models.AppConfig
-	onSelected  func(int)
-	onDelete    func(int)
+	list          *widget.List
+	items         []*models.ClipboardItem
+	config        *models.AppConfig
+	query         *services.Query
+	onSelected    func(*models.ClipboardItem)
+	onDelete      func(*models.ClipboardItem)
+	onTogglePin   func(*models.ClipboardItem)
+	thumbnail     func(item *models.ClipboardItem, ready func()) image.Image
+	duplicates    map[string][]*models.ClipboardItem
 	selectedIndex int
+	masked        bool
 }
 
 func NewListView(config *models.AppConfig) *ListView {
 	lv := &ListView{
-		historyData: binding.NewStringList(),
-		config:      config,
+		config:        config,
 		selectedIndex: -1,
 	}
-	
-	lv.list = widget.NewListWithData(
-		lv.historyData,
-		func() fyne.CanvasObject { 
+
+	lv.list = widget.NewList(
+		func() int {
+			return len(lv.items)
+		},
+		func() fyne.CanvasObject {
 			deleteBtn := widget.NewButton("🗑️", nil)
 			deleteBtn.Resize(fyne.NewSize(30, 30))
-			
-			label := widget.NewLabel("item")
+			pinBtn := widget.NewButton("📌", nil)
+
+			thumb := canvas.NewImageFromImage(nil)
+			thumb.FillMode = canvas.ImageFillContain
+			thumb.SetMinSize(fyne.NewSize(thumbnailSide, thumbnailSide))
+
+			label := widget.NewRichText()
 			label.Wrapping = fyne.TextWrapOff
-			
-			return container.NewHBox(deleteBtn, label)
+
+			return container.NewHBox(deleteBtn, pinBtn, thumb, label)
 		},
-		func(di binding.DataItem, co fyne.CanvasObject) {
-			str, _ := di.(binding.String).Get()
-			parts := strings.SplitN(str, "\t", 3)
-			
+		func(id widget.ListItemID, co fyne.CanvasObject) {
+			if id < 0 || id >= len(lv.items) {
+				return
+			}
+			item := lv.items[id]
+
 			containerObj := co.(*fyne.Container)
 			deleteBtn := containerObj.Objects[0].(*widget.Button)
-			lbl := containerObj.Objects[1].(*widget.Label)
-			
-			// Get the current index by finding this item in the data
-			items, _ := lv.historyData.Get()
-			currentIndex := -1
-			for i, item := range items {
-				if item == str {
-					currentIndex = i
-					break
+			pinBtn := containerObj.Objects[1].(*widget.Button)
+			thumb := containerObj.Objects[2].(*canvas.Image)
+			lbl := containerObj.Objects[3].(*widget.RichText)
+
+			deleteBtn.OnTapped = func() {
+				if lv.onDelete != nil {
+					lv.onDelete(item)
 				}
 			}
-			
-			deleteBtn.OnTapped = func() {
-				if lv.onDelete != nil && currentIndex >= 0 {
-					lv.onDelete(currentIndex)
+
+			pinBtn.OnTapped = func() {
+				if lv.onTogglePin != nil {
+					lv.onTogglePin(item)
 				}
 			}
-			
-			if len(parts) == 3 && parts[2] == "IMAGE" {
-				displayText := parts[0] + " [IMAGE]"
-				lbl.SetText(displayText)
-			} else if len(parts) >= 2 {
-				timestamp := parts[0]
-				content := parts[1]
-				truncatedContent := utils.TruncateText(content, config.MaxDisplayLength)
-				displayText := timestamp + " " + truncatedContent
-				lbl.SetText(displayText)
+			if item.Pinned {
+				pinBtn.Importance = widget.HighImportance
 			} else {
-				displayText := utils.TruncateText(str, config.MaxDisplayLength)
-				lbl.SetText(displayText)
+				pinBtn.Importance = widget.LowImportance
 			}
+			pinBtn.Refresh()
+
+			lv.showThumbnail(thumb, id, item)
+
+			lbl.Segments = lv.displaySegments(item)
+			lbl.Refresh()
 		},
 	)
-	
+
 	lv.list.OnSelected = func(id widget.ListItemID) {
 		lv.selectedIndex = id
-		if lv.onSelected != nil {
-			lv.onSelected(id)
+		if lv.onSelected != nil && id >= 0 && id < len(lv.items) {
+			lv.onSelected(lv.items[id])
 		}
 	}
-	
+
 	return lv
 }
 
+// showThumbnail fills the thumbnail slot of a row. Thumbnails are only
+// requested for the rows being drawn, and a row is redrawn when its
+// thumbnail is ready, if it still shows the same item.
+func (lv *ListView) showThumbnail(thumb *canvas.Image, id widget.ListItemID, item *models.ClipboardItem) {
+	thumb.Image = nil
+	if item.Type != models.ClipImage || lv.masked || lv.thumbnail == nil {
+		thumb.Hide()
+		return
+	}
+	thumb.Image = lv.thumbnail(item, func() {
+		fyne.Do(func() {
+			if id < len(lv.items) && lv.items[id].ID == item.ID {
+				lv.list.RefreshItem(id)
+			}
+		})
+	})
+	thumb.Show()
+	thumb.Refresh()
+}
+
+// displaySegments renders "timestamp content", with the parts of the content
+// matched by the current query in bold.
+func (lv *ListView) displaySegments(item *models.ClipboardItem) []widget.RichTextSegment {
+	timestamp := lv.formatTime(item.Timestamp)
+	if lv.masked {
+		return []widget.RichTextSegment{plainSegment(timestamp + " ••••••••")}
+	}
+	if item.Type == models.ClipImage {
+		// 相似圖片：最新一張標示數量，其餘縮排在下
+		if group := lv.duplicates[item.ID]; len(group) > 1 {
+			if group[0].ID == item.ID {
+				return []widget.RichTextSegment{plainSegment(fmt.Sprintf("%s [IMAGE] · 相似 %d 張", timestamp, len(group)))}
+			}
+			return []widget.RichTextSegment{plainSegment("↳ " + timestamp + " [IMAGE]")}
+		}
+		return []widget.RichTextSegment{plainSegment(timestamp + " [IMAGE]")}
+	}
+
+	content := utils.TruncateText(item.Content, lv.config.MaxDisplayLength)
+	segments := []widget.RichTextSegment{plainSegment(timestamp + " ")}
+	if lv.query == nil {
+		return append(segments, plainSegment(content))
+	}
+
+	runes := []rune(content)
+	pos := 0
+	for _, span := range lv.query.Highlight(content) {
+		if span.Start < pos || span.End > len(runes) {
+			continue
+		}
+		if span.Start > pos {
+			segments = append(segments, plainSegment(string(runes[pos:span.Start])))
+		}
+		segments = append(segments, &widget.TextSegment{
+			Text:  string(runes[span.Start:span.End]),
+			Style: widget.RichTextStyleStrong,
+		})
+		pos = span.End
+	}
+	if pos < len(runes) {
+		segments = append(segments, plainSegment(string(runes[pos:])))
+	}
+	return segments
+}
+
+// formatTime renders a timestamp the way the settings ask for.
+func (lv *ListView) formatTime(t time.Time) string {
+	if lv.config.RelativeTimestamps {
+		return utils.FormatRelative(t, time.Now(), lv.config.Location(), lv.config.TimestampFormat)
+	}
+	return utils.FormatTime(t, lv.config.Location(), lv.config.TimestampFormat)
+}
+
+// RefreshLabels redraws the rows in place, e.g. so relative times advance.
+func (lv *ListView) RefreshLabels() {
+	lv.list.Refresh()
+}
+
+func plainSegment(text string) *widget.TextSegment {
+	return &widget.TextSegment{Text: text, Style: widget.RichTextStyleInline}
+}
+
+// SetQuery sets the query whose matches are highlighted in the labels.
+func (lv *ListView) SetQuery(q *services.Query) {
+	lv.query = q
+	lv.list.Refresh()
+}
+
+// SetNearDuplicates sets the groups of similar images, keyed by item ID.
+func (lv *ListView) SetNearDuplicates(groups map[string][]*models.ClipboardItem) {
+	lv.duplicates = groups
+	lv.list.Refresh()
+}
+
+// SetMasked hides the content of every row while the app is locked.
+func (lv *ListView) SetMasked(masked bool) {
+	lv.masked = masked
+	lv.list.Refresh()
+}
+
 func (lv *ListView) GetWidget() *widget.List {
 	return lv.list
 }
 
-func (lv *ListView) SetOnSelected(callback func(int)) {
+func (lv *ListView) SetOnSelected(callback func(*models.ClipboardItem)) {
 	lv.onSelected = callback
 }
 
-func (lv *ListView) SetOnDelete(callback func(int)) {
+func (lv *ListView) SetOnDelete(callback func(*models.ClipboardItem)) {
 	lv.onDelete = callback
 }
 
+func (lv *ListView) SetOnTogglePin(callback func(*models.ClipboardItem)) {
+	lv.onTogglePin = callback
+}
+
+// SetThumbnailLoader sets where image rows get their thumbnails. It returns
+// nil while one is being built and calls ready, on any goroutine, when done.
+func (lv *ListView) SetThumbnailLoader(loader func(item *models.ClipboardItem, ready func()) image.Image) {
+	lv.thumbnail = loader
+}
+
+// LoadFromHistory shows the items with the pinned ones in a section on top.
 func (lv *ListView) LoadFromHistory(items []*models.ClipboardItem) {
-	lines := make([]string, len(items))
-	for i, item := range items {
-		timestamp := utils.FormatTimestamp(item.Timestamp, utils.GetTaipeiLocation())
-		
-		if item.Type == models.ClipImage {
-			lines[i] = timestamp + "\t" + item.FilePath + "\t" + item.Type.String()
-		} else {
-			lines[i] = timestamp + "\t" + item.Content
+	lv.items = make([]*models.ClipboardItem, 0, len(items))
+	for _, item := range items {
+		if item.Pinned {
+			lv.items = append(lv.items, item)
 		}
 	}
-	lv.historyData.Set(lines)
-	
+	for _, item := range items {
+		if !item.Pinned {
+			lv.items = append(lv.items, item)
+		}
+	}
+	lv.selectedIndex = -1
+	lv.list.UnselectAll()
+	lv.list.Refresh()
+
 	// 自動選取第一筆記錄
-	if len(lines) > 0 {
+	if len(lv.items) > 0 {
 		lv.SelectFirst()
 	}
 }
 
+// PrependItem adds a new item right below the pinned section, or on top of
+// it when the item is pinned.
 func (lv *ListView) PrependItem(item *models.ClipboardItem) {
-	timestamp := utils.FormatTimestamp(item.Timestamp, utils.GetTaipeiLocation())
-	
-	var line string
-	if item.Type == models.ClipImage {
-		line = timestamp + "\t" + item.FilePath + "\t" + item.Type.String()
-	} else {
-		line = timestamp + "\t" + item.Content
+	at := 0
+	for !item.Pinned && at < len(lv.items) && lv.items[at].Pinned {
+		at++
 	}
-	
-	lv.historyData.Prepend(line)
-	
-	// 自動選取新添加的第一筆項目
-	lv.SelectFirst()
+	lv.items = append(lv.items[:at], append([]*models.ClipboardItem{item}, lv.items[at:]...)...)
+	lv.list.Refresh()
+
+	// 自動選取新添加的項目
+	lv.SelectItem(item.ID)
 }
 
 func (lv *ListView) Clear() {
-	lv.historyData.Set([]string{})
+	lv.items = nil
 	lv.selectedIndex = -1
+	lv.list.UnselectAll()
+	lv.list.Refresh()
 }
 
 func (lv *ListView) SelectFirst() {
-	if items, _ := lv.historyData.Get(); len(items) > 0 {
+	if len(lv.items) > 0 {
 		lv.selectedIndex = 0
 		lv.list.Select(0)
 		// 觸發選擇回調以確保 UI 狀態同步
 		if lv.onSelected != nil {
-			lv.onSelected(0)
+			lv.onSelected(lv.items[0])
 		}
 	}
 }
 
-func (lv *ListView) GetSelectedItem() (string, bool) {
-	items, _ := lv.historyData.Get()
-	
-	if lv.selectedIndex < 0 || lv.selectedIndex >= len(items) {
-		return "", false
+// SelectItem selects the row showing the item with the given ID, if any.
+func (lv *ListView) SelectItem(id string) bool {
+	for i, item := range lv.items {
+		if item.ID == id {
+			lv.selectedIndex = i
+			lv.list.Select(i)
+			return true
+		}
 	}
-	
-	return items[lv.selectedIndex], true
+	return false
 }
 
-func (lv *ListView) RemoveItemAt(index int) {
-	items, _ := lv.historyData.Get()
-	if index < 0 || index >= len(items) {
+func (lv *ListView) GetSelectedItem() (*models.ClipboardItem, bool) {
+	if lv.selectedIndex < 0 || lv.selectedIndex >= len(lv.items) {
+		return nil, false
+	}
+
+	return lv.items[lv.selectedIndex], true
+}
+
+func (lv *ListView) RemoveItem(id string) {
+	index := -1
+	for i, item := range lv.items {
+		if item.ID == id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
 		return
 	}
-	
-	newItems := append(items[:index], items[index+1:]...)
-	lv.historyData.Set(newItems)
-	
+
+	lv.items = append(lv.items[:index], lv.items[index+1:]...)
+	lv.list.Refresh()
+
 	// Adjust selected index if necessary
 	if lv.selectedIndex >= index {
 		if lv.selectedIndex > 0 {
 			lv.selectedIndex--
-		} else if len(newItems) == 0 {
+		} else if len(lv.items) == 0 {
 			lv.selectedIndex = -1
 		}
 	}
-	
+
 	// Update selection in the list
-	if lv.selectedIndex >= 0 && lv.selectedIndex < len(newItems) {
+	if lv.selectedIndex >= 0 && lv.selectedIndex < len(lv.items) {
 		lv.list.Select(lv.selectedIndex)
 	} else {
 		lv.list.UnselectAll()
 	}
-}
\ No newline at end of file
+}
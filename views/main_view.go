@@ -1,6 +1,10 @@
 package views
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/widget"
@@ -14,10 +18,20 @@ type MainView struct {
 	listView            *ListView
 	detailView          *DetailView
 	toolbar             *Toolbar
+	searchEntry         *widget.Entry
+	collectionList      *widget.List
+	collections         []string
+	selectedCollection  string
 	statusLabel         *widget.Label
 	clipboardController *controllers.ClipboardController
 	config              *models.AppConfig
 	currentSelectedItem *models.ClipboardItem
+	nearDuplicates      map[string][]*models.ClipboardItem
+	window              fyne.Window
+	locked              bool
+	lastActivity        time.Time
+	lastCheck           time.Time
+	lastRelative        time.Time
 }
 
 func NewMainView(clipboardController *controllers.ClipboardController, config *models.AppConfig) *MainView {
@@ -32,9 +46,30 @@ func NewMainView(clipboardController *controllers.ClipboardController, config *m
 }
 
 func (mv *MainView) Initialize(window fyne.Window) {
+	mv.window = window
+	mv.lastActivity = time.Now()
 	mv.listView = NewListView(mv.config)
-	mv.detailView = NewDetailView()
+	mv.detailView = NewDetailView(mv.config)
 	mv.toolbar = NewToolbar(window, mv.clipboardController)
+	mv.searchEntry = widget.NewEntry()
+	mv.searchEntry.SetPlaceHolder("🔍 搜尋… 'exact /regex/ type:image before:2026-10-01 len>500")
+	mv.collectionList = widget.NewList(
+		func() int {
+			// Collection names stay hidden and unselectable while locked
+			if mv.locked {
+				return 0
+			}
+			return len(mv.collections) + 1
+		},
+		func() fyne.CanvasObject { return widget.NewLabel("collection") },
+		func(id widget.ListItemID, co fyne.CanvasObject) {
+			if id == 0 {
+				co.(*widget.Label).SetText("全部")
+			} else if id-1 < len(mv.collections) {
+				co.(*widget.Label).SetText("📁 " + mv.collections[id-1])
+			}
+		},
+	)
 	
 	mv.setupEventHandlers()
 	mv.loadInitialData()
@@ -44,25 +79,108 @@ func (mv *MainView) Initialize(window fyne.Window) {
 func (mv *MainView) setupEventHandlers() {
 	mv.listView.SetOnSelected(mv.onItemSelected)
 	mv.listView.SetOnDelete(mv.onDeleteItem)
+	mv.listView.SetOnTogglePin(mv.onTogglePin)
+	mv.listView.SetThumbnailLoader(mv.clipboardController.Thumbnail)
 	mv.detailView.SetOnSave(mv.onSaveItem)
+	mv.detailView.SetOnKeepBest(mv.onKeepBest)
+	mv.detailView.SetImageLoader(mv.clipboardController.LoadImage)
 	
 	mv.toolbar.SetOnCopy(mv.onCopyToClipboard)
 	mv.toolbar.SetOnClear(mv.onClearHistory)
 	mv.toolbar.SetOnExport(mv.onExportHistory)
 	mv.toolbar.SetOnStatusUpdate(mv.updateStatus)
+
+	mv.searchEntry.OnChanged = func(string) {
+		mv.touch()
+		mv.refreshList()
+	}
+	mv.detailView.SetOnLabels(mv.onLabelsChanged)
+	mv.toolbar.SetOnShareCollection(mv.onShareCollection)
+	mv.toolbar.SetOnImportCollection(mv.onImportCollection)
+	mv.toolbar.SetOnLock(mv.onLockButton)
+	mv.toolbar.SetOnSettings(mv.onSettings)
+	mv.detailView.SetOnUnlock(mv.promptUnlock)
+	mv.window.Canvas().SetOnTypedKey(func(*fyne.KeyEvent) { mv.touch() })
+	mv.collectionList.OnSelected = func(id widget.ListItemID) {
+		if mv.locked {
+			return
+		}
+		mv.touch()
+		mv.selectedCollection = ""
+		if id > 0 && id-1 < len(mv.collections) {
+			mv.selectedCollection = mv.collections[id-1]
+		}
+		mv.refreshList()
+	}
 }
 
 func (mv *MainView) loadInitialData() {
 	items := mv.clipboardController.GetHistoryItems()
+	mv.refreshNearDuplicates()
+	mv.listView.LoadFromHistory(items)
+	mv.refreshCollections()
+}
+
+func (mv *MainView) refreshCollections() {
+	mv.collections = mv.clipboardController.GetCollections()
+	mv.detailView.SetCollections(mv.collections)
+	mv.collectionList.Refresh()
+
+	for i, name := range mv.collections {
+		if name == mv.selectedCollection {
+			mv.collectionList.Select(i + 1)
+			return
+		}
+	}
+	mv.selectedCollection = ""
+	mv.collectionList.Select(0)
+}
+
+// refreshList reloads the list, narrowed down by the selected collection and
+// the query in the search box.
+func (mv *MainView) refreshList() {
+	text := mv.searchEntry.Text
+	if mv.selectedCollection != "" {
+		text += ` in:"` + mv.selectedCollection + `"`
+	}
+	items, query, err := mv.clipboardController.QueryHistory(text)
+	if err != nil {
+		mv.updateStatus("搜尋語法錯誤: " + err.Error())
+		return
+	}
+	if query.IsEmpty() {
+		query = nil
+	}
+	mv.listView.SetQuery(query)
+	mv.refreshNearDuplicates()
 	mv.listView.LoadFromHistory(items)
+	if len(items) == 0 {
+		mv.detailView.Clear()
+		mv.currentSelectedItem = nil
+	}
+}
+
+// refreshNearDuplicates regroups similar images after the history changed.
+func (mv *MainView) refreshNearDuplicates() {
+	mv.nearDuplicates = mv.clipboardController.NearDuplicateGroups()
+	mv.listView.SetNearDuplicates(mv.nearDuplicates)
+	if mv.currentSelectedItem != nil {
+		mv.detailView.SetNearDuplicates(len(mv.nearDuplicates[mv.currentSelectedItem.ID]))
+	}
+}
+
+func (mv *MainView) isFiltering() bool {
+	return strings.TrimSpace(mv.searchEntry.Text) != "" || mv.selectedCollection != ""
 }
 
 func (mv *MainView) buildLayout() {
-	left := container.NewBorder(nil, nil, nil, nil, mv.listView.GetWidget())
+	listPanel := container.NewBorder(mv.searchEntry, nil, nil, nil, mv.listView.GetWidget())
+	left := container.NewHSplit(mv.collectionList, listPanel)
+	left.SetOffset(0.25)
 	right := container.NewBorder(nil, mv.statusLabel, nil, nil, mv.detailView.GetWidget())
 	
 	split := container.NewHSplit(left, right)
-	split.SetOffset(0.35)
+	split.SetOffset(0.45)
 	
 	mv.content = container.NewBorder(mv.toolbar.GetWidget(), nil, nil, nil, split)
 }
@@ -71,75 +189,136 @@ func (mv *MainView) GetContent() *fyne.Container {
 	return mv.content
 }
 
-func (mv *MainView) onItemSelected(index int) {
-	item := mv.clipboardController.GetHistoryItem(index)
+func (mv *MainView) onItemSelected(item *models.ClipboardItem) {
 	if item == nil {
 		return
 	}
 	
+	mv.touch()
 	mv.currentSelectedItem = item
 	mv.detailView.ShowItem(item)
+	mv.detailView.SetNearDuplicates(len(mv.nearDuplicates[item.ID]))
 }
 
-func (mv *MainView) onDeleteItem(index int) {
-	err := mv.clipboardController.RemoveHistoryItem(index)
+// onKeepBest removes the similar images of the selected one, except the
+// best capture.
+func (mv *MainView) onKeepBest() {
+	if mv.locked || mv.currentSelectedItem == nil {
+		return
+	}
+	best, removed, err := mv.clipboardController.KeepBestNearDuplicate(mv.currentSelectedItem.ID)
+	if err != nil {
+		mv.updateStatus("保留最佳失敗: " + err.Error())
+	} else {
+		mv.updateStatus(fmt.Sprintf("已保留最佳，移除 %d 張相似圖片", len(removed)))
+	}
+	mv.refreshList()
+	if best != nil {
+		mv.listView.SelectItem(best.ID)
+	}
+}
+
+func (mv *MainView) onDeleteItem(item *models.ClipboardItem) {
+	if mv.locked {
+		return
+	}
+	err := mv.clipboardController.RemoveHistoryItem(item.ID)
 	if err != nil {
 		mv.updateStatus("刪除失敗: " + err.Error())
 		return
 	}
 	
-	mv.listView.RemoveItemAt(index)
+	mv.listView.RemoveItem(item.ID)
+	mv.refreshNearDuplicates()
 	
 	// If the deleted item was selected, clear the detail view
-	if mv.currentSelectedItem != nil {
-		item := mv.clipboardController.GetHistoryItem(index)
-		if item == nil {
-			mv.detailView.Clear()
-			mv.currentSelectedItem = nil
-		}
+	if mv.currentSelectedItem != nil && mv.currentSelectedItem.ID == item.ID {
+		mv.detailView.Clear()
+		mv.currentSelectedItem = nil
 	}
 	
 	mv.updateStatus("項目已刪除")
 }
 
-func (mv *MainView) onSaveItem(newContent string) {
+func (mv *MainView) onTogglePin(item *models.ClipboardItem) {
+	if mv.locked {
+		return
+	}
+	pinned := !item.Pinned
+	if err := mv.clipboardController.SetItemPinned(item.ID, pinned); err != nil {
+		mv.updateStatus("釘選失敗: " + err.Error())
+		return
+	}
+
+	mv.refreshList()
+	mv.listView.SelectItem(item.ID)
+	if pinned {
+		mv.updateStatus("已釘選")
+	} else {
+		mv.updateStatus("已取消釘選")
+	}
+}
+
+func (mv *MainView) onLabelsChanged(collection string, tags []string) {
+	if mv.locked {
+		return
+	}
 	if mv.currentSelectedItem == nil {
 		mv.updateStatus("沒有選中的項目")
 		return
 	}
-	
-	// Find the index of current selected item
-	items := mv.clipboardController.GetHistoryItems()
-	selectedIndex := -1
-	for i, item := range items {
-		if item == mv.currentSelectedItem {
-			selectedIndex = i
-			break
-		}
+
+	selectedID := mv.currentSelectedItem.ID
+	if err := mv.clipboardController.SetItemLabels(selectedID, collection, tags); err != nil {
+		mv.updateStatus("標籤儲存失敗: " + err.Error())
+		return
 	}
-	
-	if selectedIndex == -1 {
-		mv.updateStatus("找不到選中的項目")
+
+	mv.refreshCollections()
+	mv.refreshList()
+	mv.listView.SelectItem(selectedID)
+	mv.updateStatus("標籤已更新")
+}
+
+// onShareCollection exports the selected collection, or the whole history
+// when none is selected.
+func (mv *MainView) onShareCollection() ([]byte, string, error) {
+	name := "clipmini_collection.jsonl"
+	if mv.selectedCollection != "" {
+		name = mv.selectedCollection + ".jsonl"
+	}
+	data, err := mv.clipboardController.ExportCollection(mv.selectedCollection, "")
+	return data, name, err
+}
+
+func (mv *MainView) onImportCollection(data []byte) (int, error) {
+	n, err := mv.clipboardController.ImportCollection(data)
+	if err == nil {
+		mv.refreshCollections()
+		mv.refreshList()
+	}
+	return n, err
+}
+
+func (mv *MainView) onSaveItem(newContent string) {
+	if mv.locked {
+		return
+	}
+	if mv.currentSelectedItem == nil {
+		mv.updateStatus("沒有選中的項目")
 		return
 	}
 	
-	err := mv.clipboardController.UpdateHistoryItem(selectedIndex, newContent)
+	selectedID := mv.currentSelectedItem.ID
+	err := mv.clipboardController.UpdateHistoryItem(selectedID, newContent)
 	if err != nil {
 		mv.updateStatus("保存失敗: " + err.Error())
 		return
 	}
 	
-	// Update the current item content
-	mv.currentSelectedItem.Content = newContent
-	
-	// Reload the list to reflect changes
-	mv.listView.LoadFromHistory(mv.clipboardController.GetHistoryItems())
-	
-	// Reselect the item
-	mv.listView.SelectFirst()
-	if selectedIndex < len(items) {
-		mv.listView.GetWidget().Select(selectedIndex)
-	}
+	// Reload the list to reflect changes and reselect the item
+	mv.refreshList()
+	mv.listView.SelectItem(selectedID)
 	
 	mv.updateStatus("已保存修改")
 }
@@ -163,16 +342,57 @@ func (mv *MainView) onCopyToClipboard() error {
 	return nil
 }
 
-func (mv *MainView) onClearHistory() error {
-	err := mv.clipboardController.ClearHistory()
+func (mv *MainView) onClearHistory(includePinned bool) error {
+	err := mv.clipboardController.ClearHistory(includePinned)
 	if err == nil {
-		mv.listView.Clear()
 		mv.detailView.Clear()
 		mv.currentSelectedItem = nil
+		mv.refreshList()
 	}
 	return err
 }
 
+// onSettings edits the settings file rather than the running configuration,
+// which keeps the startup storage locations until the next start.
+func (mv *MainView) onSettings() {
+	saved, err := models.LoadAppConfig(mv.config.Dirs)
+	if err != nil {
+		saved = mv.config.Clone()
+	}
+	ShowSettingsWindow(saved, func(updated *models.AppConfig) error {
+		if err := mv.clipboardController.UpdateSettings(updated); err != nil {
+			return err
+		}
+		mv.refreshList()
+		mv.updateStatus("設定已儲存，儲存位置於重新啟動後生效")
+		return nil
+	})
+}
+
+// OnConfigChanged redraws the list when a reloaded configuration changes how
+// items are shown or how many are kept.
+func (mv *MainView) OnConfigChanged(old, updated *models.AppConfig) {
+	if old.MaxDisplayLength == updated.MaxDisplayLength && old.Timezone == updated.Timezone &&
+		old.TimestampFormat == updated.TimestampFormat && old.RelativeTimestamps == updated.RelativeTimestamps &&
+		old.MaxHistoryItems == updated.MaxHistoryItems && old.Images == updated.Images {
+		return
+	}
+	fyne.Do(func() {
+		mv.inBackground(mv.refreshList)
+	})
+}
+
+// RefreshRelativeTimes advances "N 分鐘前" labels, at most once a minute.
+func (mv *MainView) RefreshRelativeTimes(now time.Time) {
+	fyne.Do(func() {
+		if !mv.config.RelativeTimestamps || now.Sub(mv.lastRelative) < time.Minute {
+			return
+		}
+		mv.lastRelative = now
+		mv.listView.RefreshLabels()
+	})
+}
+
 func (mv *MainView) onExportHistory() string {
 	return mv.clipboardController.ExportHistory()
 }
@@ -183,7 +403,16 @@ func (mv *MainView) updateStatus(message string) {
 
 func (mv *MainView) OnNewClipboardItem(item *models.ClipboardItem) {
 	fyne.Do(func() {
-		mv.listView.PrependItem(item)
+		mv.inBackground(func() {
+			if mv.isFiltering() {
+				mv.refreshList()
+			} else {
+				// A collapsed near-duplicate comes back under the ID it replaced
+				mv.listView.RemoveItem(item.ID)
+				mv.refreshNearDuplicates()
+				mv.listView.PrependItem(item)
+			}
+		})
 		
 		if item.Type == models.ClipImage {
 			mv.updateStatus("圖片已記錄")
@@ -191,4 +420,21 @@ func (mv *MainView) OnNewClipboardItem(item *models.ClipboardItem) {
 			mv.updateStatus("文字已記錄")
 		}
 	})
-}
\ No newline at end of file
+}
+
+// OnItemsRemoved drops items removed in the background, e.g. expired ones.
+func (mv *MainView) OnItemsRemoved(items []*models.ClipboardItem) {
+	fyne.Do(func() {
+		mv.inBackground(func() {
+			defer mv.refreshNearDuplicates()
+			for _, item := range items {
+				mv.listView.RemoveItem(item.ID)
+				if mv.currentSelectedItem != nil && mv.currentSelectedItem.ID == item.ID {
+					mv.detailView.Clear()
+					mv.currentSelectedItem = nil
+				}
+			}
+		})
+		mv.updateStatus("已移除過期項目")
+	})
+}
@@ -0,0 +1,306 @@
+package views
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"clipmini/models"
+)
+
+var privacyActions = []string{
+	string(models.PrivacyAllow), string(models.PrivacyMask), string(models.PrivacyExpire), string(models.PrivacySkip),
+}
+
+// SettingsView edits a copy of the configuration in its own window; onSave
+// validates, stores and applies it.
+type SettingsView struct {
+	window fyne.Window
+	config *models.AppConfig
+	onSave func(*models.AppConfig) error
+
+	maxItems      *widget.Entry
+	pollInterval  *widget.Entry
+	displayLength *widget.Entry
+	timezone      *widget.Entry
+	timeFormat    *widget.Entry
+	relativeTimes *widget.Check
+	lockAfter     *widget.Entry
+
+	dataDir      *widget.Entry
+	historyStore *widget.Select
+	historyFile  *widget.Entry
+	historyDB    *widget.Entry
+	imageDir     *widget.Entry
+	imageFormat  *widget.Select
+	jpegQuality  *widget.Entry
+	maxDimension *widget.Entry
+	maxBytes     *widget.Entry
+	nearDups     *widget.Select
+	nearDistance *widget.Entry
+	nearWindow   *widget.Entry
+
+	privacyEnabled *widget.Check
+	defaultAction  *widget.Select
+	expireAfter    *widget.Entry
+	detectKeys     *widget.Check
+	detectCards    *widget.Check
+	detectEntropy  *widget.Check
+	privacyRules   *widget.Entry
+	retentionRules *widget.Entry
+}
+
+func ShowSettingsWindow(config *models.AppConfig, onSave func(*models.AppConfig) error) {
+	sv := &SettingsView{
+		window: fyne.CurrentApp().NewWindow("設定"),
+		config: config,
+		onSave: onSave,
+	}
+	sv.build()
+	sv.window.Resize(fyne.NewSize(560, 460))
+	sv.window.Show()
+}
+
+func (sv *SettingsView) build() {
+	c := sv.config
+	sv.maxItems = intEntry(c.MaxHistoryItems)
+	sv.pollInterval = intEntry(c.PollingInterval)
+	sv.displayLength = intEntry(c.MaxDisplayLength)
+	sv.timezone = textEntry(c.Timezone)
+	sv.timeFormat = textEntry(c.TimestampFormat)
+	sv.timeFormat.SetPlaceHolder("Go 格式，例如 2006-01-02 15:04:05")
+	sv.relativeTimes = widget.NewCheck("列表顯示「3 分鐘前」", nil)
+	sv.relativeTimes.SetChecked(c.RelativeTimestamps)
+	sv.lockAfter = intEntry(c.LockAfterSeconds)
+
+	sv.dataDir = textEntry(c.LogDirPath)
+	sv.historyStore = widget.NewSelect([]string{"jsonl", "bolt"}, nil)
+	sv.historyStore.SetSelected(c.HistoryStore)
+	sv.historyFile = textEntry(c.LogFilePath)
+	sv.historyDB = textEntry(c.HistoryDBPath)
+	sv.imageDir = textEntry(c.ImageDirPath)
+	sv.imageFormat = widget.NewSelect([]string{models.ImageFormatPNG, models.ImageFormatJPEG}, nil)
+	sv.imageFormat.SetSelected(c.Images.Format)
+	sv.jpegQuality = intEntry(c.Images.JPEGQuality)
+	sv.maxDimension = intEntry(c.Images.MaxDimension)
+	sv.maxBytes = intEntry(c.Images.MaxBytes)
+	sv.nearDups = widget.NewSelect([]string{models.NearDuplicatesOff, models.NearDuplicatesCollapse, models.NearDuplicatesGroup}, nil)
+	sv.nearDups.SetSelected(c.Images.NearDuplicates)
+	sv.nearDistance = intEntry(c.Images.NearDuplicateDistance)
+	sv.nearWindow = intEntry(c.Images.NearDuplicateWindow)
+
+	p := c.Privacy
+	sv.privacyEnabled = widget.NewCheck("啟用", nil)
+	sv.privacyEnabled.SetChecked(p.Enabled)
+	sv.defaultAction = widget.NewSelect(privacyActions, nil)
+	sv.defaultAction.SetSelected(string(p.DefaultAction))
+	sv.expireAfter = intEntry(p.ExpireAfterSeconds)
+	sv.detectKeys = widget.NewCheck("已知金鑰格式", nil)
+	sv.detectKeys.SetChecked(p.DetectKnownKeys)
+	sv.detectCards = widget.NewCheck("信用卡號", nil)
+	sv.detectCards.SetChecked(p.DetectCards)
+	sv.detectEntropy = widget.NewCheck("高亂度字串", nil)
+	sv.detectEntropy.SetChecked(p.DetectEntropy)
+	sv.privacyRules = widget.NewMultiLineEntry()
+	sv.privacyRules.SetPlaceHolder("名稱 | 動作 | 正規表示式，一行一條")
+	sv.privacyRules.SetText(formatPrivacyRules(p.Rules))
+	sv.retentionRules = widget.NewMultiLineEntry()
+	sv.retentionRules.SetPlaceHolder("名稱 | 保留秒數 | 查詢，例如 舊圖片 | 86400 | type:image")
+	sv.retentionRules.SetText(formatRetentionRules(c.Retention))
+
+	general := widget.NewForm(
+		widget.NewFormItem("歷史筆數上限", sv.maxItems),
+		widget.NewFormItem("輪詢間隔（毫秒）", sv.pollInterval),
+		widget.NewFormItem("顯示長度", sv.displayLength),
+		widget.NewFormItem("時區", sv.timezone),
+		widget.NewFormItem("時間格式", sv.timeFormat),
+		widget.NewFormItem("相對時間", sv.relativeTimes),
+		widget.NewFormItem("閒置鎖定（秒）", sv.lockAfter),
+	)
+	storage := widget.NewForm(
+		widget.NewFormItem("資料夾", sv.dataDir),
+		widget.NewFormItem("儲存方式", sv.historyStore),
+		widget.NewFormItem("歷史檔", sv.historyFile),
+		widget.NewFormItem("資料庫", sv.historyDB),
+		widget.NewFormItem("圖片資料夾", sv.imageDir),
+		widget.NewFormItem("圖片格式", sv.imageFormat),
+		widget.NewFormItem("JPEG 品質", sv.jpegQuality),
+		widget.NewFormItem("最長邊（像素，0 不限）", sv.maxDimension),
+		widget.NewFormItem("大小上限（位元組，0 不限）", sv.maxBytes),
+		widget.NewFormItem("相似圖片", sv.nearDups),
+		widget.NewFormItem("相似門檻（0-64 位元）", sv.nearDistance),
+		widget.NewFormItem("相似時間窗（秒，0 不限）", sv.nearWindow),
+	)
+	privacy := widget.NewForm(
+		widget.NewFormItem("隱私過濾", sv.privacyEnabled),
+		widget.NewFormItem("預設動作", sv.defaultAction),
+		widget.NewFormItem("過期秒數", sv.expireAfter),
+		widget.NewFormItem("偵測", container.NewHBox(sv.detectKeys, sv.detectCards, sv.detectEntropy)),
+		widget.NewFormItem("自訂規則", sv.privacyRules),
+	)
+	retention := widget.NewForm(widget.NewFormItem("保留規則", sv.retentionRules))
+
+	tabs := container.NewAppTabs(
+		container.NewTabItem("一般", general),
+		container.NewTabItem("儲存", storage),
+		container.NewTabItem("隱私", privacy),
+		container.NewTabItem("保留", retention),
+	)
+	buttons := container.NewHBox(
+		widget.NewButton("取消", sv.window.Close),
+		widget.NewButton("💾 儲存", sv.save),
+	)
+	sv.window.SetContent(container.NewBorder(nil, container.NewBorder(nil, nil, nil, buttons), nil, nil, tabs))
+}
+
+func (sv *SettingsView) save() {
+	updated, err := sv.collect()
+	if err == nil {
+		err = sv.onSave(updated)
+	}
+	if err != nil {
+		dialog.ShowError(err, sv.window)
+		return
+	}
+	sv.window.Close()
+}
+
+// collect builds the edited configuration. Paths left unchanged follow a
+// new data directory.
+func (sv *SettingsView) collect() (*models.AppConfig, error) {
+	updated := sv.config.Clone()
+	var err error
+	ints := []struct {
+		entry *widget.Entry
+		label string
+		dst   *int
+	}{
+		{sv.maxItems, "歷史筆數上限", &updated.MaxHistoryItems},
+		{sv.pollInterval, "輪詢間隔", &updated.PollingInterval},
+		{sv.displayLength, "顯示長度", &updated.MaxDisplayLength},
+		{sv.lockAfter, "閒置鎖定", &updated.LockAfterSeconds},
+		{sv.expireAfter, "過期秒數", &updated.Privacy.ExpireAfterSeconds},
+		{sv.jpegQuality, "JPEG 品質", &updated.Images.JPEGQuality},
+		{sv.maxDimension, "最長邊", &updated.Images.MaxDimension},
+		{sv.maxBytes, "大小上限", &updated.Images.MaxBytes},
+		{sv.nearDistance, "相似門檻", &updated.Images.NearDuplicateDistance},
+		{sv.nearWindow, "相似時間窗", &updated.Images.NearDuplicateWindow},
+	}
+	for _, f := range ints {
+		if *f.dst, err = strconv.Atoi(strings.TrimSpace(f.entry.Text)); err != nil {
+			return nil, fmt.Errorf("%s必須是整數", f.label)
+		}
+	}
+	updated.Timezone = strings.TrimSpace(sv.timezone.Text)
+	updated.TimestampFormat = sv.timeFormat.Text
+	updated.RelativeTimestamps = sv.relativeTimes.Checked
+
+	updated.SetDataDir(strings.TrimSpace(sv.dataDir.Text))
+	updated.HistoryStore = sv.historyStore.Selected
+	updated.Images.Format = sv.imageFormat.Selected
+	updated.Images.NearDuplicates = sv.nearDups.Selected
+	for _, f := range []struct {
+		entry    *widget.Entry
+		original string
+		dst      *string
+	}{
+		{sv.historyFile, sv.config.LogFilePath, &updated.LogFilePath},
+		{sv.historyDB, sv.config.HistoryDBPath, &updated.HistoryDBPath},
+		{sv.imageDir, sv.config.ImageDirPath, &updated.ImageDirPath},
+	} {
+		if text := strings.TrimSpace(f.entry.Text); text != f.original {
+			*f.dst = text
+		}
+	}
+
+	updated.Privacy.Enabled = sv.privacyEnabled.Checked
+	updated.Privacy.DefaultAction = models.PrivacyAction(sv.defaultAction.Selected)
+	updated.Privacy.DetectKnownKeys = sv.detectKeys.Checked
+	updated.Privacy.DetectCards = sv.detectCards.Checked
+	updated.Privacy.DetectEntropy = sv.detectEntropy.Checked
+	if updated.Privacy.Rules, err = parsePrivacyRules(sv.privacyRules.Text); err != nil {
+		return nil, err
+	}
+	if updated.Retention, err = parseRetentionRules(sv.retentionRules.Text); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func intEntry(n int) *widget.Entry {
+	return textEntry(strconv.Itoa(n))
+}
+
+func textEntry(text string) *widget.Entry {
+	e := widget.NewEntry()
+	e.SetText(text)
+	return e
+}
+
+// ruleFields splits "a | b | rest" into three trimmed fields; the last one
+// may contain "|" itself.
+func ruleFields(line string) ([]string, bool) {
+	fields := strings.SplitN(line, "|", 3)
+	if len(fields) != 3 {
+		return nil, false
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields, true
+}
+
+func parsePrivacyRules(text string) ([]models.PrivacyRule, error) {
+	var rules []models.PrivacyRule
+	for n, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		f, ok := ruleFields(line)
+		if !ok {
+			return nil, fmt.Errorf("隱私規則第 %d 行格式錯誤", n+1)
+		}
+		rules = append(rules, models.PrivacyRule{Name: f[0], Action: models.PrivacyAction(f[1]), Pattern: f[2]})
+	}
+	return rules, nil
+}
+
+func formatPrivacyRules(rules []models.PrivacyRule) string {
+	lines := make([]string, len(rules))
+	for i, r := range rules {
+		lines[i] = r.Name + " | " + string(r.Action) + " | " + r.Pattern
+	}
+	return strings.Join(lines, "\n")
+}
+
+func parseRetentionRules(text string) ([]models.RetentionRule, error) {
+	var rules []models.RetentionRule
+	for n, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		f, ok := ruleFields(line)
+		if !ok {
+			return nil, fmt.Errorf("保留規則第 %d 行格式錯誤", n+1)
+		}
+		maxAge, err := strconv.Atoi(f[1])
+		if err != nil {
+			return nil, fmt.Errorf("保留規則第 %d 行：保留秒數必須是整數", n+1)
+		}
+		rules = append(rules, models.RetentionRule{Name: f[0], MaxAgeSeconds: maxAge, Query: f[2]})
+	}
+	return rules, nil
+}
+
+func formatRetentionRules(rules []models.RetentionRule) string {
+	lines := make([]string, len(rules))
+	for i, r := range rules {
+		lines[i] = fmt.Sprintf("%s | %d | %s", r.Name, r.MaxAgeSeconds, r.Query)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -1,6 +1,9 @@
 package views
 
 import (
+	"fmt"
+	"io"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
@@ -14,11 +17,20 @@ type Toolbar struct {
 	copyBtn              *widget.Button
 	clearBtn             *widget.Button
 	exportBtn            *widget.Button
+	shareBtn             *widget.Button
+	importBtn            *widget.Button
+	encryptBtn           *widget.Button
+	lockBtn              *widget.Button
+	settingsBtn          *widget.Button
 	clipboardController  *controllers.ClipboardController
 	onCopy               func() error
-	onClear              func() error
+	onClear              func(includePinned bool) error
 	onExport             func() string
+	onShareCollection    func() ([]byte, string, error)
+	onImportCollection   func([]byte) (int, error)
 	onStatusUpdate       func(string)
+	onLock               func()
+	onSettings           func()
 	window               fyne.Window
 }
 
@@ -31,8 +43,21 @@ func NewToolbar(window fyne.Window, clipboardController *controllers.ClipboardCo
 	tb.copyBtn = widget.NewButton("複製回剪貼簿", tb.handleCopy)
 	tb.clearBtn = widget.NewButton("清空", tb.handleClear)
 	tb.exportBtn = widget.NewButton("匯出到檔案", tb.handleExport)
+	tb.shareBtn = widget.NewButton("分享集合", tb.handleShareCollection)
+	tb.importBtn = widget.NewButton("匯入集合", tb.handleImportCollection)
+	tb.encryptBtn = widget.NewButton("🔐 加密", tb.handleEncryption)
+	tb.lockBtn = widget.NewButton("🔒 鎖定", func() {
+		if tb.onLock != nil {
+			tb.onLock()
+		}
+	})
+	tb.settingsBtn = widget.NewButton("⚙️ 設定", func() {
+		if tb.onSettings != nil {
+			tb.onSettings()
+		}
+	})
 	
-	tb.container = container.NewHBox(tb.copyBtn, tb.clearBtn, tb.exportBtn)
+	tb.container = container.NewHBox(tb.copyBtn, tb.clearBtn, tb.exportBtn, tb.shareBtn, tb.importBtn, tb.encryptBtn, tb.lockBtn, tb.settingsBtn)
 	
 	return tb
 }
@@ -45,7 +70,7 @@ func (tb *Toolbar) SetOnCopy(callback func() error) {
 	tb.onCopy = callback
 }
 
-func (tb *Toolbar) SetOnClear(callback func() error) {
+func (tb *Toolbar) SetOnClear(callback func(includePinned bool) error) {
 	tb.onClear = callback
 }
 
@@ -53,6 +78,39 @@ func (tb *Toolbar) SetOnExport(callback func() string) {
 	tb.onExport = callback
 }
 
+func (tb *Toolbar) SetOnShareCollection(callback func() ([]byte, string, error)) {
+	tb.onShareCollection = callback
+}
+
+func (tb *Toolbar) SetOnImportCollection(callback func([]byte) (int, error)) {
+	tb.onImportCollection = callback
+}
+
+// SetOnLock sets the handler of the lock button, which unlocks while locked.
+func (tb *Toolbar) SetOnLock(callback func()) {
+	tb.onLock = callback
+}
+
+func (tb *Toolbar) SetOnSettings(callback func()) {
+	tb.onSettings = callback
+}
+
+// SetLocked disables every action that could reveal clips while locked.
+func (tb *Toolbar) SetLocked(locked bool) {
+	for _, btn := range []*widget.Button{tb.copyBtn, tb.clearBtn, tb.exportBtn, tb.shareBtn, tb.importBtn, tb.encryptBtn, tb.settingsBtn} {
+		if locked {
+			btn.Disable()
+		} else {
+			btn.Enable()
+		}
+	}
+	if locked {
+		tb.lockBtn.SetText("🔓 解鎖")
+	} else {
+		tb.lockBtn.SetText("🔒 鎖定")
+	}
+}
+
 func (tb *Toolbar) SetOnStatusUpdate(callback func(string)) {
 	tb.onStatusUpdate = callback
 }
@@ -68,13 +126,15 @@ func (tb *Toolbar) handleCopy() {
 }
 
 func (tb *Toolbar) handleClear() {
-	dialog.ShowConfirm("清空", "確定要清空歷史？（會刪除已存圖片）", func(ok bool) {
+	includePinned := widget.NewCheck("一併清除釘選項目", nil)
+	content := container.NewVBox(widget.NewLabel("確定要清空歷史？（會刪除已存圖片）"), includePinned)
+	dialog.ShowCustomConfirm("清空", "清空", "取消", content, func(ok bool) {
 		if !ok {
 			return
 		}
 		
 		if tb.onClear != nil {
-			if err := tb.onClear(); err != nil {
+			if err := tb.onClear(includePinned.Checked); err != nil {
 				tb.updateStatus("清空失敗")
 			} else {
 				tb.updateStatus("已清空")
@@ -101,6 +161,101 @@ func (tb *Toolbar) handleExport() {
 	fd.Show()
 }
 
+func (tb *Toolbar) handleShareCollection() {
+	if tb.onShareCollection == nil {
+		return
+	}
+	data, name, err := tb.onShareCollection()
+	if err != nil {
+		tb.updateStatus("匯出失敗")
+		return
+	}
+
+	fd := dialog.NewFileSave(func(uc fyne.URIWriteCloser, err error) {
+		if err != nil || uc == nil {
+			return
+		}
+		defer uc.Close()
+		
+		if _, err := uc.Write(data); err != nil {
+			tb.updateStatus("匯出失敗")
+			return
+		}
+		tb.updateStatus("集合已匯出")
+	}, tb.window)
+	
+	fd.SetFileName(name)
+	fd.Show()
+}
+
+func (tb *Toolbar) handleImportCollection() {
+	fd := dialog.NewFileOpen(func(uc fyne.URIReadCloser, err error) {
+		if err != nil || uc == nil {
+			return
+		}
+		defer uc.Close()
+		
+		data, err := io.ReadAll(uc)
+		if err != nil || tb.onImportCollection == nil {
+			tb.updateStatus("匯入失敗")
+			return
+		}
+		n, err := tb.onImportCollection(data)
+		if err != nil {
+			tb.updateStatus("匯入失敗: " + err.Error())
+			return
+		}
+		tb.updateStatus(fmt.Sprintf("已匯入 %d 筆", n))
+	}, tb.window)
+	fd.Show()
+}
+
+// handleEncryption turns on encryption of the saved history, or changes the
+// passphrase once it is on.
+func (tb *Toolbar) handleEncryption() {
+	enabled := tb.clipboardController.EncryptionEnabled()
+	current := widget.NewPasswordEntry()
+	next := widget.NewPasswordEntry()
+	confirm := widget.NewPasswordEntry()
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("新密碼", next),
+		widget.NewFormItem("確認新密碼", confirm),
+	}
+	title := "加密紀錄"
+	if enabled {
+		items = append([]*widget.FormItem{widget.NewFormItem("目前密碼", current)}, items...)
+		title = "更換密碼"
+	}
+
+	form := dialog.NewForm(title, "確定", "取消", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		if next.Text != confirm.Text {
+			tb.updateStatus("兩次輸入的密碼不同")
+			return
+		}
+
+		var err error
+		if enabled {
+			err = tb.clipboardController.ChangePassphrase(current.Text, next.Text)
+		} else {
+			err = tb.clipboardController.EnableEncryption(next.Text)
+		}
+		switch {
+		case err != nil:
+			tb.updateStatus("加密失敗: " + err.Error())
+		case enabled:
+			tb.updateStatus("已更換密碼")
+		default:
+			tb.updateStatus("紀錄與圖片已加密")
+		}
+	}, tb.window)
+	form.Resize(fyne.NewSize(380, 220))
+	form.Show()
+}
+
 func (tb *Toolbar) updateStatus(message string) {
 	if tb.onStatusUpdate != nil {
 		tb.onStatusUpdate(message)
@@ -0,0 +1,37 @@
+package views
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// ShowUnlockPrompt asks for the passphrase of an encrypted history until
+// unlock accepts it, then calls onUnlocked. Cancelling closes the window.
+func ShowUnlockPrompt(window fyne.Window, unlock func(string) error, onUnlocked func()) {
+	showPassphrasePrompt(window, "🔐 解鎖", "紀錄已加密，請輸入密碼", "結束", unlock, onUnlocked, window.Close)
+}
+
+// showPassphrasePrompt asks again with an error hint until verify accepts the
+// passphrase or the user cancels.
+func showPassphrasePrompt(window fyne.Window, title, hint, dismiss string, verify func(string) error, onOK, onCancel func()) {
+	passphrase := widget.NewPasswordEntry()
+	items := []*widget.FormItem{{Text: "密碼", Widget: passphrase, HintText: hint}}
+
+	form := dialog.NewForm(title, "解鎖", dismiss, items, func(ok bool) {
+		if !ok {
+			if onCancel != nil {
+				onCancel()
+			}
+			return
+		}
+		if err := verify(passphrase.Text); err != nil {
+			showPassphrasePrompt(window, title, "密碼錯誤，請再試一次", dismiss, verify, onOK, onCancel)
+			return
+		}
+		onOK()
+	}, window)
+	form.Resize(fyne.NewSize(360, 160))
+	form.Show()
+	window.Canvas().Focus(passphrase)
+}